@@ -1,17 +1,26 @@
 package app
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/ArjunMalhotra/config"
+	"github.com/ArjunMalhotra/internal/events"
+	"github.com/ArjunMalhotra/internal/metrics"
 	"github.com/ArjunMalhotra/internal/repo"
 	"github.com/ArjunMalhotra/internal/server"
 	"github.com/ArjunMalhotra/internal/services"
+	"github.com/ArjunMalhotra/pkg/clicktoken"
+	"github.com/ArjunMalhotra/pkg/counter"
 	"github.com/ArjunMalhotra/pkg/db"
+	"github.com/ArjunMalhotra/pkg/dedup"
 	"github.com/ArjunMalhotra/pkg/http"
 	"github.com/ArjunMalhotra/pkg/logger"
+	"github.com/ArjunMalhotra/pkg/schemaregistry"
+	"github.com/go-redis/redis"
 )
 
 func Start() {
@@ -21,6 +30,17 @@ func Start() {
 	//! logger
 	log, _ := logger.NewLogger(cfg)
 	app := http.NewApp(log)
+	//! Redis, only needed by the redis backends
+	var redisClient *redis.Client
+	if cfg.Counter.Backend == config.BackendRedis || cfg.Dedup.Backend == config.BackendRedis {
+		client, err := db.NewRedisClient(cfg)
+		if err != nil {
+			log.Logger.Errorf("Failed to connect to Redis: %v", err)
+			return
+		}
+		defer client.Close()
+		redisClient = client
+	}
 	//! mysql db
 	db, err := db.NewMysqDB(cfg)
 	if err != nil {
@@ -46,18 +66,92 @@ func Start() {
 			log.Logger.Info("Successfully seeded data")
 		}
 	}
-	//! Kafka
-	kafkaService, err := services.NewKafkaService(cfg.Kafka.Brokers, log)
+	//! Event bus
+	bus, err := newEventBus(cfg, log)
 	if err != nil {
-		log.Logger.Errorf("Failed to initialize Kafka: %v", err)
+		log.Logger.Errorf("Failed to initialize event bus: %v", err)
 		return
 	}
-	defer kafkaService.Close()
+	defer bus.Close()
 	clickRepo := repo.NewClickRepo(db.DB)
-	clickService := services.NewClickService(clickRepo, log, kafkaService)
-	adService := services.NewAdService(adRepo, log)
+	campaignRepo := repo.NewCampaignRepo(db.DB)
+	advertiserRepo := repo.NewAdvertiserRepo(db.DB)
+	impressionRepo := repo.NewImpressionRepo(db.DB)
+	conversionRepo := repo.NewConversionRepo(db.DB)
+	//! Fraud scoring
+	datacenterRule, err := services.NewDatacenterIPRule(cfg.Fraud.DatacenterCIDRs)
+	if err != nil {
+		log.Logger.Errorf("Failed to load datacenter ranges: %v", err)
+		return
+	}
+	pendingClicks := services.NewPendingClicks()
+	fraudDetector := services.NewFraudDetector(log,
+		services.NewIPVelocityRule(clickRepo, pendingClicks, cfg.Fraud.MaxClicksPerIPHour),
+		services.NewIPAdRepeatRule(clickRepo, pendingClicks, cfg.Fraud.MaxClicksPerIPAdHour),
+		services.NewPlaybackTimeRule(cfg.Fraud.MaxPlaybackSeconds),
+		datacenterRule,
+	)
+	//! Click counters and dedup of consumed messages
+	clickCounter, err := newClickCounter(cfg, redisClient)
+	if err != nil {
+		log.Logger.Error(err)
+		return
+	}
+	processedClicks, err := newDedupStore(cfg, redisClient, "clicks")
+	if err != nil {
+		log.Logger.Error(err)
+		return
+	}
+	processedImpressions, err := newDedupStore(cfg, redisClient, "impressions")
+	if err != nil {
+		log.Logger.Error(err)
+		return
+	}
+	//! Click events, encoded with schemas from the registry
+	clickCodec, err := events.NewClickCodec(schemaregistry.NewLocalRegistry(), cfg.ClickEvent.Encoding)
+	if err != nil {
+		log.Logger.Error(err)
+		return
+	}
+	//! Circuit breakers of the services, by name
+	breakers, err := services.NewBreakerRegistry(cfg.Breakers, log)
+	if err != nil {
+		log.Logger.Error(err)
+		return
+	}
+	metrics.RegisterCircuitBreakers(breakers)
+	clickService := services.NewClickService(clickRepo, log, breakers, bus, cfg.Kafka.Topics, clickCodec, fraudDetector, pendingClicks, clickCounter, processedClicks, cfg.ClickBatch.Size, cfg.ClickBatch.FlushInterval, services.ClickRetryPolicy{
+		MaxAttempts: cfg.ClickBatch.MaxAttempts,
+		Delay:       cfg.ClickBatch.RetryDelay,
+	})
+	impressionService := services.NewImpressionService(impressionRepo, clickRepo, log, breakers, bus, cfg.Kafka.Topics.Impressions, processedImpressions, cfg.Impression)
+	conversionService := services.NewConversionService(conversionRepo, clickRepo, log, breakers, cfg.Conversion.AttributionWindow)
+	adService := services.NewAdService(adRepo, campaignRepo, log, breakers)
+	campaignService := services.NewCampaignService(campaignRepo, advertiserRepo, adRepo, clickRepo, log, breakers)
+	advertiserService := services.NewAdvertiserService(advertiserRepo, campaignRepo, adRepo, clickRepo, log, breakers)
+	//! Click rollups
+	rollupCompactor := services.NewRollupCompactor(clickRepo, log, cfg.Rollup.Interval, cfg.Rollup.Lateness)
+	rollupCompactor.Start()
+	defer rollupCompactor.Stop()
+	//! Click signing
+	var clickSigner *clicktoken.Signer
+	if len(cfg.ClickToken.SigningKeys) > 0 {
+		keys := make([]clicktoken.Key, len(cfg.ClickToken.SigningKeys))
+		for i, key := range cfg.ClickToken.SigningKeys {
+			keys[i] = clicktoken.Key{ID: key.ID, Secret: []byte(key.Secret)}
+		}
+		clickSigner, err = clicktoken.NewSigner(keys, cfg.ClickToken.TTL)
+		if err != nil {
+			log.Logger.Errorf("Failed to initialize click signer: %v", err)
+			return
+		}
+	} else {
+		log.Logger.Warn("CLICK_SIGNING_KEYS not set, clicks are accepted without a signed token")
+	}
+	//! Health checks
+	healthService := services.NewHealthService(db, bus, breakers, log, cfg.Health.Timeout)
 	//! Fiber based HTTP server
-	server := server.NewHTTP(cfg, app, log, adService, clickService)
+	server := server.NewHTTP(cfg, app, log, adService, clickService, campaignService, advertiserService, impressionService, conversionService, healthService, clickSigner, bus, breakers)
 	//! start http server
 	go func() {
 		err := server.App.Listen(cfg.Http.Host + cfg.Http.Port)
@@ -74,4 +168,45 @@ func Start() {
 	if err := server.App.Shutdown(); err != nil {
 		log.Logger.Fatalf("Server forced to shutdown: %v", err)
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	if err := clickService.Shutdown(ctx); err != nil {
+		log.Logger.Errorf("Failed to drain clicks: %v", err)
+	}
+	if err := impressionService.Shutdown(ctx); err != nil {
+		log.Logger.Errorf("Failed to stop impressions: %v", err)
+	}
+}
+
+// newEventBus returns the event bus picked by EVENT_BUS_BACKEND
+func newEventBus(cfg *config.Config, log *logger.Logger) (services.EventBus, error) {
+	switch cfg.EventBus.Backend {
+	case config.BackendKafka:
+		return services.NewKafkaService(cfg.Kafka, log)
+	case config.BackendMemory:
+		return services.NewMemoryBus(cfg.EventBus.Buffer, cfg.EventBus.Retention), nil
+	}
+	return nil, fmt.Errorf("unknown event bus backend %q, use %s or %s", cfg.EventBus.Backend, config.BackendKafka, config.BackendMemory)
+}
+
+// newClickCounter returns the click counter backend picked by COUNTER_BACKEND
+func newClickCounter(cfg *config.Config, redisClient *redis.Client) (counter.Counter, error) {
+	switch cfg.Counter.Backend {
+	case config.BackendMemory:
+		return counter.NewMemoryCounter(cfg.Counter.TTL, cfg.Counter.BucketTTL), nil
+	case config.BackendRedis:
+		return counter.NewRedisCounter(redisClient, cfg.Counter.TTL, cfg.Counter.BucketTTL), nil
+	}
+	return nil, fmt.Errorf("unknown counter backend %q, use %s or %s", cfg.Counter.Backend, config.BackendMemory, config.BackendRedis)
+}
+
+// newDedupStore returns the dedup store picked by DEDUP_BACKEND for one stream of messages
+func newDedupStore(cfg *config.Config, redisClient *redis.Client, stream string) (dedup.Store, error) {
+	switch cfg.Dedup.Backend {
+	case config.BackendMemory:
+		return dedup.NewMemoryStore(cfg.Dedup.Capacity, cfg.Dedup.TTL), nil
+	case config.BackendRedis:
+		return dedup.NewRedisStore(redisClient, stream, cfg.Dedup.TTL), nil
+	}
+	return nil, fmt.Errorf("unknown dedup backend %q, use %s or %s", cfg.Dedup.Backend, config.BackendMemory, config.BackendRedis)
 }
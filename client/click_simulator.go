@@ -24,11 +24,19 @@ type ClickRequest struct {
 	AdID         string `json:"ad_id"`
 	IP           string `json:"ip"`
 	PlaybackTime int    `json:"playback_time"`
+	ClickToken   string `json:"click_token,omitempty"`
+}
+
+// ImpressionResponse is what the server answers when an impression is recorded
+type ImpressionResponse struct {
+	ImpressionID string `json:"impression_id"`
+	ClickToken   string `json:"click_token"` // only set when the server signs clicks
 }
 
 func main() {
 	// Configuration
 	serverURL := "http://localhost:8888/ads/click"
+	impressionURL := "http://localhost:8888/ads/impression"
 	totalClicks := 450 // Target total clicks
 	batchSize := 50    // Clicks per batch
 	pauseDuration := 1 * time.Minute
@@ -62,8 +70,16 @@ func main() {
 				PlaybackTime: rand.Intn(300) + 1, // Random playback time between 1-300 seconds
 			}
 
+			// Show the ad first, the impression carries the click token
+			token, err := sendImpression(impressionURL, adID)
+			if err != nil {
+				fmt.Printf("Error sending impression for ad %s: %v\n", adID, err)
+				continue
+			}
+			clickReq.ClickToken = token
+
 			// Send the request
-			err := sendClick(serverURL, clickReq)
+			err = sendClick(serverURL, clickReq)
 			if err != nil {
 				fmt.Printf("Error sending click for ad %s: %v\n", adID, err)
 			} else {
@@ -92,6 +108,31 @@ func main() {
 	}
 }
 
+// sendImpression records an impression and returns the click token issued for it
+func sendImpression(impressionURL string, adID string) (string, error) {
+	jsonData, err := json.Marshal(map[string]string{"ad_id": adID})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling JSON: %v", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(impressionURL, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(body))
+	}
+	var impression ImpressionResponse
+	if err := json.Unmarshal(body, &impression); err != nil {
+		return "", fmt.Errorf("error decoding response: %v", err)
+	}
+	return impression.ClickToken, nil
+}
+
 // sendClick sends a click request to the server
 func sendClick(serverURL string, click ClickRequest) error {
 	// Convert the request to JSON
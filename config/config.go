@@ -1,8 +1,12 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -19,13 +23,197 @@ const (
 	MYSQL_USER     = "MYSQL_USER"
 	MYSQL_PASSWORD = "MYSQL_PASSWORD"
 	MYSQL_DB       = "MYSQL_DB"
+	//! optional
+	CONVERSION_ATTRIBUTION_WINDOW     = "CONVERSION_ATTRIBUTION_WINDOW"
+	CLICK_SIGNING_KEYS                = "CLICK_SIGNING_KEYS"
+	CLICK_TOKEN_TTL                   = "CLICK_TOKEN_TTL"
+	FRAUD_MAX_CLICKS_PER_IP           = "FRAUD_MAX_CLICKS_PER_IP"
+	FRAUD_MAX_CLICKS_PER_IP_AD        = "FRAUD_MAX_CLICKS_PER_IP_AD"
+	FRAUD_MAX_PLAYBACK_SECONDS        = "FRAUD_MAX_PLAYBACK_SECONDS"
+	FRAUD_DATACENTER_CIDRS            = "FRAUD_DATACENTER_CIDRS"
+	ROLLUP_INTERVAL                   = "ROLLUP_INTERVAL"
+	ROLLUP_LATENESS                   = "ROLLUP_LATENESS"
+	COUNTER_BACKEND                   = "COUNTER_BACKEND"
+	COUNTER_TTL                       = "COUNTER_TTL"
+	COUNTER_BUCKET_TTL                = "COUNTER_BUCKET_TTL"
+	DEDUP_BACKEND                     = "DEDUP_BACKEND"
+	DEDUP_TTL                         = "DEDUP_TTL"
+	DEDUP_CAPACITY                    = "DEDUP_CAPACITY"
+	CLICK_BATCH_SIZE                  = "CLICK_BATCH_SIZE"
+	CLICK_FLUSH_INTERVAL              = "CLICK_FLUSH_INTERVAL"
+	SHUTDOWN_TIMEOUT                  = "SHUTDOWN_TIMEOUT"
+	CLICK_RETRY_MAX_ATTEMPTS          = "CLICK_RETRY_MAX_ATTEMPTS"
+	CLICK_RETRY_DELAY                 = "CLICK_RETRY_DELAY"
+	IMPRESSION_BATCH_SIZE             = "IMPRESSION_BATCH_SIZE"
+	IMPRESSION_FLUSH_INTERVAL         = "IMPRESSION_FLUSH_INTERVAL"
+	IMPRESSION_MAX_ATTEMPTS           = "IMPRESSION_MAX_ATTEMPTS"
+	KAFKA_PARTITIONER                 = "KAFKA_PARTITIONER"
+	KAFKA_PRODUCER_MODE               = "KAFKA_PRODUCER_MODE"
+	KAFKA_PRODUCER_QUEUE_SIZE         = "KAFKA_PRODUCER_QUEUE_SIZE"
+	KAFKA_PRODUCER_LINGER             = "KAFKA_PRODUCER_LINGER"
+	KAFKA_PRODUCER_BATCH_SIZE         = "KAFKA_PRODUCER_BATCH_SIZE"
+	KAFKA_PRODUCER_COMPRESSION        = "KAFKA_PRODUCER_COMPRESSION"
+	KAFKA_PRODUCER_DRAIN_TIMEOUT      = "KAFKA_PRODUCER_DRAIN_TIMEOUT"
+	KAFKA_CLICK_TOPIC                 = "KAFKA_CLICK_TOPIC"
+	KAFKA_CLICK_GROUP                 = "KAFKA_CLICK_GROUP"
+	KAFKA_CLICK_WORKERS               = "KAFKA_CLICK_WORKERS"
+	KAFKA_CLICK_RETRY_TOPIC           = "KAFKA_CLICK_RETRY_TOPIC"
+	KAFKA_CLICK_RETRY_GROUP           = "KAFKA_CLICK_RETRY_GROUP"
+	KAFKA_CLICK_RETRY_WORKERS         = "KAFKA_CLICK_RETRY_WORKERS"
+	KAFKA_CLICK_DLQ_TOPIC             = "KAFKA_CLICK_DLQ_TOPIC"
+	KAFKA_IMPRESSION_TOPIC            = "KAFKA_IMPRESSION_TOPIC"
+	KAFKA_IMPRESSION_GROUP            = "KAFKA_IMPRESSION_GROUP"
+	KAFKA_IMPRESSION_WORKERS          = "KAFKA_IMPRESSION_WORKERS"
+	KAFKA_TOPIC_PARTITIONS            = "KAFKA_TOPIC_PARTITIONS"
+	KAFKA_TOPIC_REPLICATION           = "KAFKA_TOPIC_REPLICATION"
+	KAFKA_CONSUMER_INITIAL_OFFSET     = "KAFKA_CONSUMER_INITIAL_OFFSET"
+	KAFKA_CONSUMER_SESSION_TIMEOUT    = "KAFKA_CONSUMER_SESSION_TIMEOUT"
+	KAFKA_CONSUMER_HEARTBEAT_INTERVAL = "KAFKA_CONSUMER_HEARTBEAT_INTERVAL"
+	KAFKA_CONSUMER_REBALANCE_TIMEOUT  = "KAFKA_CONSUMER_REBALANCE_TIMEOUT"
+	KAFKA_SASL_MECHANISM              = "KAFKA_SASL_MECHANISM"
+	KAFKA_SASL_USER                   = "KAFKA_SASL_USER"
+	KAFKA_SASL_PASSWORD               = "KAFKA_SASL_PASSWORD"
+	KAFKA_TLS_ENABLED                 = "KAFKA_TLS_ENABLED"
+	KAFKA_TLS_CA_FILE                 = "KAFKA_TLS_CA_FILE"
+	KAFKA_TLS_CERT_FILE               = "KAFKA_TLS_CERT_FILE"
+	KAFKA_TLS_KEY_FILE                = "KAFKA_TLS_KEY_FILE"
+	KAFKA_TLS_INSECURE_SKIP_VERIFY    = "KAFKA_TLS_INSECURE_SKIP_VERIFY"
+	EVENT_BUS_BACKEND                 = "EVENT_BUS_BACKEND"
+	EVENT_BUS_BUFFER                  = "EVENT_BUS_BUFFER"
+	EVENT_BUS_RETENTION               = "EVENT_BUS_RETENTION"
+	CLICK_EVENT_ENCODING              = "CLICK_EVENT_ENCODING"
+	REDIS_ADDR                        = "REDIS_ADDR"
+	REDIS_PASSWORD                    = "REDIS_PASSWORD"
+	REDIS_DB                          = "REDIS_DB"
+	HEALTH_CHECK_TIMEOUT              = "HEALTH_CHECK_TIMEOUT"
+	ADMIN_TOKEN                       = "ADMIN_TOKEN"
+	CIRCUIT_BREAKER_FAILURE_THRESHOLD = "CIRCUIT_BREAKER_FAILURE_THRESHOLD"
+	CIRCUIT_BREAKER_RESET_TIMEOUT     = "CIRCUIT_BREAKER_RESET_TIMEOUT"
+	CIRCUIT_BREAKER_MAX_RESET_TIMEOUT = "CIRCUIT_BREAKER_MAX_RESET_TIMEOUT"
+	CIRCUIT_BREAKER_FAILURE_RATE      = "CIRCUIT_BREAKER_FAILURE_RATE"
+	CIRCUIT_BREAKER_WINDOW            = "CIRCUIT_BREAKER_WINDOW"
+	CIRCUIT_BREAKER_MIN_REQUESTS      = "CIRCUIT_BREAKER_MIN_REQUESTS"
+	CIRCUIT_BREAKER_HALF_OPEN_PROBES  = "CIRCUIT_BREAKER_HALF_OPEN_PROBES"
+	CIRCUIT_BREAKER_SLOW_CALL         = "CIRCUIT_BREAKER_SLOW_CALL_THRESHOLD"
+	CIRCUIT_BREAKER_OVERRIDES         = "CIRCUIT_BREAKER_OVERRIDES"
+)
+
+const (
+	defaultAttributionWindow = 7 * 24 * time.Hour
+	defaultClickTokenTTL     = time.Hour
+	defaultMaxClicksPerIP    = 100
+	defaultMaxClicksPerIPAd  = 10
+	defaultMaxPlaybackTime   = 3600
+	defaultRollupInterval    = time.Minute
+	defaultRollupLateness    = 5 * time.Minute
+	defaultCounterBackend    = BackendMemory
+	defaultCounterTTL        = time.Hour
+	defaultCounterBucketTTL  = time.Hour
+	defaultDedupBackend      = BackendMemory
+	defaultDedupTTL          = 24 * time.Hour
+	defaultDedupCapacity     = 100000
+	defaultRedisAddr         = "localhost:6379"
+	defaultClickBatchSize    = 100
+	defaultClickFlushEvery   = time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultHealthTimeout     = 2 * time.Second
+	defaultBreakerThreshold  = 5
+	defaultBreakerReset      = 30 * time.Second
+	defaultBreakerMaxReset   = 5 * time.Minute
+	defaultBreakerRate       = 0.5
+	defaultBreakerWindow     = 30 * time.Second
+	defaultBreakerMinCalls   = 20
+	defaultBreakerProbes     = 3
+	defaultBreakerSlowCall   = 5 * time.Second
+	defaultClickMaxAttempts  = 5
+	defaultClickRetryDelay   = 10 * time.Second
+	defaultImpressionBatch   = 100
+	defaultImpressionFlush   = time.Second
+	defaultImpressionTries   = 5
+	defaultKafkaPartitioner  = PartitionerHash
+	defaultProducerMode      = ProducerModeSync
+	defaultProducerQueueSize = 10000
+	defaultProducerLinger    = 5 * time.Millisecond
+	defaultProducerBatchSize = 500
+	defaultCompression       = "none"
+	defaultProducerDrain     = 10 * time.Second
+	defaultClickTopic        = "ad-clicks"
+	defaultClickGroup        = "ad-clicks-group"
+	defaultClickWorkers      = 3
+	defaultClickRetryTopic   = "ad-clicks-retry"
+	defaultClickRetryGroup   = "ad-clicks-retry-group"
+	defaultClickRetryWorkers = 1
+	defaultClickDLQTopic     = "ad-clicks-dlq"
+	defaultImpressionTopic   = "ad-impressions"
+	defaultImpressionGroup   = "ad-impressions-group"
+	defaultImpressionWorkers = 3
+	defaultTopicPartitions   = 3
+	defaultTopicReplication  = 1
+	defaultInitialOffset     = OffsetOldest
+	defaultSessionTimeout    = 10 * time.Second
+	defaultHeartbeatInterval = 3 * time.Second
+	defaultRebalanceTimeout  = 60 * time.Second
+	defaultEventBusBackend   = BackendKafka
+	defaultEventBusBuffer    = 10000
+	defaultEventBusRetention = 10000
+	defaultClickEncoding     = "protobuf"
+)
+
+// Kafka producer partitioners
+const (
+	PartitionerHash       = "hash"       // FNV-1a of the key, sarama's default
+	PartitionerReference  = "reference"  // FNV-1a like the reference Java client hashes it
+	PartitionerCRC32      = "crc32"      // CRC32 of the key
+	PartitionerRandom     = "random"     // ignores the key
+	PartitionerRoundRobin = "roundrobin" // ignores the key
+)
+
+// where a consumer group without committed offsets starts
+const (
+	OffsetOldest = "oldest"
+	OffsetNewest = "newest"
+)
+
+// SASL mechanisms, none when KAFKA_SASL_MECHANISM is empty
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// how recorded clicks are published
+const (
+	ProducerModeSync  = "sync"  // in the background, waiting for every ack
+	ProducerModeAsync = "async" // through a bounded queue, batched
+)
+
+// backends of the click counters, the dedup stores and the event bus
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+	BackendKafka  = "kafka"
 )
 
 type Config struct {
-	Http   HttpConfig
-	Logger LoggerConfig
-	Kafka  KafkaConfig
-	MySQL  MySQLConfig
+	Http       HttpConfig
+	Logger     LoggerConfig
+	Kafka      KafkaConfig
+	EventBus   EventBusConfig
+	ClickEvent ClickEventConfig
+	MySQL      MySQLConfig
+	Conversion ConversionConfig
+	ClickToken ClickTokenConfig
+	Fraud      FraudConfig
+	Rollup     RollupConfig
+	Counter    CounterConfig
+	Dedup      DedupConfig
+	Redis      RedisConfig
+	ClickBatch ClickBatchConfig
+	Impression ImpressionConfig
+	Shutdown   ShutdownConfig
+	Health     HealthConfig
+	Breakers   CircuitBreakerConfig
+	Admin      AdminConfig
 }
 
 type MySQLConfig struct {
@@ -47,9 +235,230 @@ type LoggerConfig struct {
 
 type KafkaConfig struct {
 	Brokers []string
+	// how produced messages, keyed by ad ID, are spread over partitions
+	Partitioner string
+	Producer    ProducerConfig
+	Consumer    ConsumerConfig
+	Topics      TopicsConfig
+	SASL        SASLConfig
+	TLS         TLSConfig
+}
+
+type ConsumerConfig struct {
+	// oldest or newest, where a group without committed offsets starts
+	InitialOffset string
+	// a worker that doesn't heartbeat for this long is dropped from its group
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	// how long workers get to rejoin when the group rebalances
+	RebalanceTimeout time.Duration
+}
+
+// TopicsConfig names the topics and consumer groups, with the memory event bus as well
+type TopicsConfig struct {
+	Clicks           StreamConfig
+	ClickRetries     StreamConfig
+	Impressions      StreamConfig
+	ClickDeadLetters string
+	// of the topics the app creates
+	Partitions  int
+	Replication int
+}
+
+// StreamConfig is a topic and the consumer group reading it
+type StreamConfig struct {
+	Topic   string
+	Group   string
+	Workers int
+}
+
+type SASLConfig struct {
+	// PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, empty disables SASL
+	Mechanism string
+	User      string
+	Password  string
+}
+
+type TLSConfig struct {
+	Enabled bool
+	// PEM files, the CA defaults to the system roots and the client certificate is optional
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+type ProducerConfig struct {
+	Mode string
+	// unacknowledged messages the async producer holds before recording clicks fails
+	QueueSize int
+	// how long the async producer waits to fill a batch
+	Linger time.Duration
+	// messages per batch of the async producer
+	BatchSize int
+	// none, gzip, snappy, lz4 or zstd
+	Compression string
+	// how long closing waits for messages still being published
+	DrainTimeout time.Duration
+}
+
+type EventBusConfig struct {
+	// kafka, or memory to run without a broker in a single process
+	Backend string
+	// unconsumed messages the memory backend holds per consumer group
+	Buffer int
+	// messages the memory backend keeps per topic for reading dead letters
+	Retention int
+}
+
+type ClickEventConfig struct {
+	// protobuf, or json to keep writing bare clicks while consumers are upgraded
+	Encoding string
+}
+
+type ConversionConfig struct {
+	// a conversion only counts for an ad if it happens within this long after the click
+	AttributionWindow time.Duration
+}
+
+type ClickTokenConfig struct {
+	// the first key signs, all keys verify; empty disables click signing
+	SigningKeys []SigningKey
+	TTL         time.Duration
+}
+
+type FraudConfig struct {
+	MaxClicksPerIPHour   int // across all ads
+	MaxClicksPerIPAdHour int // on a single ad
+	MaxPlaybackSeconds   int
+	DatacenterCIDRs      []string
+}
+
+type RollupConfig struct {
+	// how often raw clicks are compacted into the rollup tables
+	Interval time.Duration
+	// how long after its timestamp a click may still arrive and be rolled up
+	Lateness time.Duration
+}
+
+type CounterConfig struct {
+	// memory keeps click counters per process, redis shares them between instances
+	Backend string
+	// how long a counter read from the database is trusted before it is read again
+	TTL time.Duration
+	// how long per-minute click buckets are kept
+	BucketTTL time.Duration
+}
+
+type DedupConfig struct {
+	// memory dedups consumed messages per process, redis across consumer instances
+	Backend string
+	// how long a processed message ID is remembered
+	TTL time.Duration
+	// how many IDs the memory backend remembers per stream
+	Capacity int
+}
+
+type ClickBatchConfig struct {
+	// consumed clicks are stored once this many are batched
+	Size int
+	// or once this long has passed, whichever comes first
+	FlushInterval time.Duration
+	// attempts at a click, including the first, before it goes to the dead-letter topic
+	MaxAttempts int
+	// wait before retrying a click, doubled for every further attempt
+	RetryDelay time.Duration
+}
+
+type ImpressionConfig struct {
+	// consumed impressions are stored once this many are batched
+	BatchSize int
+	// or once this long has passed, whichever comes first
+	FlushInterval time.Duration
+	// attempts at an impression, including the first, before it is dropped
+	MaxAttempts int
+}
+
+type ShutdownConfig struct {
+	// how long in-flight work may take to drain on shutdown
+	Timeout time.Duration
+}
+
+type HealthConfig struct {
+	// how long /readyz waits for each dependency to answer
+	Timeout time.Duration
+}
+
+type AdminConfig struct {
+	// bearer token the /admin endpoints require, they are disabled when empty
+	Token string
+}
+
+type CircuitBreakerConfig struct {
+	// settings of every breaker without an override
+	Defaults BreakerConfig
+	// by breaker name, e.g. "click-service", on top of the defaults
+	Overrides map[string]BreakerConfig
+}
+
+// BreakerConfig are the thresholds of a circuit breaker, a zero failure threshold, failure rate
+// or slow call threshold turns that policy off
+type BreakerConfig struct {
+	FailureThreshold  int
+	ResetTimeout      time.Duration
+	MaxResetTimeout   time.Duration
+	FailureRate       float64 // 0 to 1
+	Window            time.Duration
+	MinRequests       int
+	HalfOpenProbes    int
+	SlowCallThreshold time.Duration
+}
+
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+}
+
+type SigningKey struct {
+	ID     string
+	Secret string
+}
+
+// String keeps secrets out of the config dump printed at startup
+func (k SigningKey) String() string {
+	return k.ID + ":<redacted>"
+}
+
+// String keeps the password out of the config dump printed at startup
+func (s SASLConfig) String() string {
+	return fmt.Sprintf("{%s %s <redacted>}", s.Mechanism, s.User)
+}
+
+// String keeps the password out of the config dump printed at startup
+func (r RedisConfig) String() string {
+	return fmt.Sprintf("{%s <redacted> %d}", r.Addr, r.DB)
+}
+
+// String keeps the token out of the config dump printed at startup
+func (a AdminConfig) String() string {
+	if a.Token == "" {
+		return "{token unset}"
+	}
+	return "{token set}"
 }
 
 func NewConfig() *Config {
+	breakerDefaults := BreakerConfig{
+		FailureThreshold:  getEnvCount(CIRCUIT_BREAKER_FAILURE_THRESHOLD, defaultBreakerThreshold),
+		ResetTimeout:      getEnvDuration(CIRCUIT_BREAKER_RESET_TIMEOUT, defaultBreakerReset),
+		MaxResetTimeout:   getEnvDuration(CIRCUIT_BREAKER_MAX_RESET_TIMEOUT, defaultBreakerMaxReset),
+		FailureRate:       getEnvRate(CIRCUIT_BREAKER_FAILURE_RATE, defaultBreakerRate),
+		Window:            getEnvDuration(CIRCUIT_BREAKER_WINDOW, defaultBreakerWindow),
+		MinRequests:       getEnvInt(CIRCUIT_BREAKER_MIN_REQUESTS, defaultBreakerMinCalls),
+		HalfOpenProbes:    getEnvInt(CIRCUIT_BREAKER_HALF_OPEN_PROBES, defaultBreakerProbes),
+		SlowCallThreshold: getEnvNonNegativeDuration(CIRCUIT_BREAKER_SLOW_CALL, defaultBreakerSlowCall),
+	}
 	c := Config{
 		Http: HttpConfig{
 			Host: getEnv(HTTP_HOST),
@@ -59,7 +468,62 @@ func NewConfig() *Config {
 			LogFile: getEnv(LOG_FILE),
 		},
 		Kafka: KafkaConfig{
-			Brokers: []string{getEnv(KAFKA_BROKER)},
+			Brokers:     []string{getEnv(KAFKA_BROKER)},
+			Partitioner: getEnvDefault(KAFKA_PARTITIONER, defaultKafkaPartitioner),
+			Producer: ProducerConfig{
+				Mode:         getEnvDefault(KAFKA_PRODUCER_MODE, defaultProducerMode),
+				QueueSize:    getEnvInt(KAFKA_PRODUCER_QUEUE_SIZE, defaultProducerQueueSize),
+				Linger:       getEnvDuration(KAFKA_PRODUCER_LINGER, defaultProducerLinger),
+				BatchSize:    getEnvInt(KAFKA_PRODUCER_BATCH_SIZE, defaultProducerBatchSize),
+				Compression:  getEnvDefault(KAFKA_PRODUCER_COMPRESSION, defaultCompression),
+				DrainTimeout: getEnvDuration(KAFKA_PRODUCER_DRAIN_TIMEOUT, defaultProducerDrain),
+			},
+			Consumer: ConsumerConfig{
+				InitialOffset:     getEnvDefault(KAFKA_CONSUMER_INITIAL_OFFSET, defaultInitialOffset),
+				SessionTimeout:    getEnvDuration(KAFKA_CONSUMER_SESSION_TIMEOUT, defaultSessionTimeout),
+				HeartbeatInterval: getEnvDuration(KAFKA_CONSUMER_HEARTBEAT_INTERVAL, defaultHeartbeatInterval),
+				RebalanceTimeout:  getEnvDuration(KAFKA_CONSUMER_REBALANCE_TIMEOUT, defaultRebalanceTimeout),
+			},
+			Topics: TopicsConfig{
+				Clicks: StreamConfig{
+					Topic:   getEnvDefault(KAFKA_CLICK_TOPIC, defaultClickTopic),
+					Group:   getEnvDefault(KAFKA_CLICK_GROUP, defaultClickGroup),
+					Workers: getEnvInt(KAFKA_CLICK_WORKERS, defaultClickWorkers),
+				},
+				ClickRetries: StreamConfig{
+					Topic:   getEnvDefault(KAFKA_CLICK_RETRY_TOPIC, defaultClickRetryTopic),
+					Group:   getEnvDefault(KAFKA_CLICK_RETRY_GROUP, defaultClickRetryGroup),
+					Workers: getEnvInt(KAFKA_CLICK_RETRY_WORKERS, defaultClickRetryWorkers),
+				},
+				Impressions: StreamConfig{
+					Topic:   getEnvDefault(KAFKA_IMPRESSION_TOPIC, defaultImpressionTopic),
+					Group:   getEnvDefault(KAFKA_IMPRESSION_GROUP, defaultImpressionGroup),
+					Workers: getEnvInt(KAFKA_IMPRESSION_WORKERS, defaultImpressionWorkers),
+				},
+				ClickDeadLetters: getEnvDefault(KAFKA_CLICK_DLQ_TOPIC, defaultClickDLQTopic),
+				Partitions:       getEnvInt(KAFKA_TOPIC_PARTITIONS, defaultTopicPartitions),
+				Replication:      getEnvInt(KAFKA_TOPIC_REPLICATION, defaultTopicReplication),
+			},
+			SASL: SASLConfig{
+				Mechanism: getEnv(KAFKA_SASL_MECHANISM),
+				User:      getEnv(KAFKA_SASL_USER),
+				Password:  getEnv(KAFKA_SASL_PASSWORD),
+			},
+			TLS: TLSConfig{
+				Enabled:            getEnvBool(KAFKA_TLS_ENABLED),
+				CAFile:             getEnv(KAFKA_TLS_CA_FILE),
+				CertFile:           getEnv(KAFKA_TLS_CERT_FILE),
+				KeyFile:            getEnv(KAFKA_TLS_KEY_FILE),
+				InsecureSkipVerify: getEnvBool(KAFKA_TLS_INSECURE_SKIP_VERIFY),
+			},
+		},
+		EventBus: EventBusConfig{
+			Backend:   getEnvDefault(EVENT_BUS_BACKEND, defaultEventBusBackend),
+			Buffer:    getEnvInt(EVENT_BUS_BUFFER, defaultEventBusBuffer),
+			Retention: getEnvInt(EVENT_BUS_RETENTION, defaultEventBusRetention),
+		},
+		ClickEvent: ClickEventConfig{
+			Encoding: getEnvDefault(CLICK_EVENT_ENCODING, defaultClickEncoding),
 		},
 		MySQL: MySQLConfig{
 			MysqlHost:     getEnv(MYSQL_HOST),
@@ -68,6 +532,62 @@ func NewConfig() *Config {
 			MysqlPassword: getEnv(MYSQL_PASSWORD),
 			MysqlDBName:   getEnv(MYSQL_DB),
 		},
+		Conversion: ConversionConfig{
+			AttributionWindow: getEnvDuration(CONVERSION_ATTRIBUTION_WINDOW, defaultAttributionWindow),
+		},
+		ClickToken: ClickTokenConfig{
+			SigningKeys: getEnvSigningKeys(CLICK_SIGNING_KEYS),
+			TTL:         getEnvDuration(CLICK_TOKEN_TTL, defaultClickTokenTTL),
+		},
+		Fraud: FraudConfig{
+			MaxClicksPerIPHour:   getEnvInt(FRAUD_MAX_CLICKS_PER_IP, defaultMaxClicksPerIP),
+			MaxClicksPerIPAdHour: getEnvInt(FRAUD_MAX_CLICKS_PER_IP_AD, defaultMaxClicksPerIPAd),
+			MaxPlaybackSeconds:   getEnvInt(FRAUD_MAX_PLAYBACK_SECONDS, defaultMaxPlaybackTime),
+			DatacenterCIDRs:      getEnvList(FRAUD_DATACENTER_CIDRS),
+		},
+		Rollup: RollupConfig{
+			Interval: getEnvDuration(ROLLUP_INTERVAL, defaultRollupInterval),
+			Lateness: getEnvDuration(ROLLUP_LATENESS, defaultRollupLateness),
+		},
+		Counter: CounterConfig{
+			Backend:   getEnvDefault(COUNTER_BACKEND, defaultCounterBackend),
+			TTL:       getEnvDuration(COUNTER_TTL, defaultCounterTTL),
+			BucketTTL: getEnvDuration(COUNTER_BUCKET_TTL, defaultCounterBucketTTL),
+		},
+		Dedup: DedupConfig{
+			Backend:  getEnvDefault(DEDUP_BACKEND, defaultDedupBackend),
+			TTL:      getEnvDuration(DEDUP_TTL, defaultDedupTTL),
+			Capacity: getEnvInt(DEDUP_CAPACITY, defaultDedupCapacity),
+		},
+		Redis: RedisConfig{
+			Addr:     getEnvDefault(REDIS_ADDR, defaultRedisAddr),
+			Password: getEnv(REDIS_PASSWORD),
+			DB:       getEnvInt(REDIS_DB, 0),
+		},
+		ClickBatch: ClickBatchConfig{
+			Size:          getEnvInt(CLICK_BATCH_SIZE, defaultClickBatchSize),
+			FlushInterval: getEnvDuration(CLICK_FLUSH_INTERVAL, defaultClickFlushEvery),
+			MaxAttempts:   getEnvInt(CLICK_RETRY_MAX_ATTEMPTS, defaultClickMaxAttempts),
+			RetryDelay:    getEnvDuration(CLICK_RETRY_DELAY, defaultClickRetryDelay),
+		},
+		Impression: ImpressionConfig{
+			BatchSize:     getEnvInt(IMPRESSION_BATCH_SIZE, defaultImpressionBatch),
+			FlushInterval: getEnvDuration(IMPRESSION_FLUSH_INTERVAL, defaultImpressionFlush),
+			MaxAttempts:   getEnvInt(IMPRESSION_MAX_ATTEMPTS, defaultImpressionTries),
+		},
+		Shutdown: ShutdownConfig{
+			Timeout: getEnvDuration(SHUTDOWN_TIMEOUT, defaultShutdownTimeout),
+		},
+		Health: HealthConfig{
+			Timeout: getEnvDuration(HEALTH_CHECK_TIMEOUT, defaultHealthTimeout),
+		},
+		Breakers: CircuitBreakerConfig{
+			Defaults:  breakerDefaults,
+			Overrides: getEnvBreakerOverrides(CIRCUIT_BREAKER_OVERRIDES, breakerDefaults),
+		},
+		Admin: AdminConfig{
+			Token: getEnv(ADMIN_TOKEN),
+		},
 	}
 	fmt.Println(c)
 	return &c
@@ -78,6 +598,192 @@ func getEnv(key string) string {
 	return value
 }
 
+// getEnvDefault reads a string and falls back to def when unset
+func getEnvDefault(key string, def string) string {
+	if value := getEnv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvDuration reads a Go duration (e.g. "168h") and falls back to def when unset or invalid
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := getEnv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		fmt.Printf("%s = %s is not a valid duration, using %s\n", key, value, def)
+		return def
+	}
+	return d
+}
+
+// getEnvInt reads a positive integer and falls back to def when unset or invalid
+func getEnvInt(key string, def int) int {
+	value := getEnv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		fmt.Printf("%s = %s is not a positive integer, using %d\n", key, value, def)
+		return def
+	}
+	return n
+}
+
+// getEnvCount reads an integer that can be zero and falls back to def when unset or invalid
+func getEnvCount(key string, def int) int {
+	value := getEnv(key)
+	if value == "" {
+		return def
+	}
+	n, err := parseCount(value)
+	if err != nil {
+		fmt.Printf("%s = %s is not a non-negative integer, using %d\n", key, value, def)
+		return def
+	}
+	return n
+}
+
+// getEnvNonNegativeDuration reads a duration that can be zero and falls back to def when unset
+// or invalid
+func getEnvNonNegativeDuration(key string, def time.Duration) time.Duration {
+	value := getEnv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		fmt.Printf("%s = %s is not a valid duration, using %s\n", key, value, def)
+		return def
+	}
+	return d
+}
+
+// getEnvBool reads a boolean, false when unset. Anything else fails at startup.
+func getEnvBool(key string) bool {
+	value := getEnv(key)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		// a typo must not quietly turn a security setting off
+		panic(fmt.Sprintf("%s = %s is not a boolean", key, value))
+	}
+	return b
+}
+
+// getEnvList reads a comma separated list, skipping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvSigningKeys reads a comma separated list of id:secret pairs, e.g. "k2:newsecret,k1:oldsecret"
+func getEnvSigningKeys(key string) []SigningKey {
+	var keys []SigningKey
+	for _, pair := range getEnvList(key) {
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			panic(fmt.Sprintf("%s entries must look like id:secret", key))
+		}
+		keys = append(keys, SigningKey{ID: id, Secret: secret})
+	}
+	return keys
+}
+
+// getEnvRate reads a fraction from 0 to 1 and falls back to def when unset or invalid
+func getEnvRate(key string, def float64) float64 {
+	value := getEnv(key)
+	if value == "" {
+		return def
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		fmt.Printf("%s = %s is not a rate from 0 to 1, using %v\n", key, value, def)
+		return def
+	}
+	return rate
+}
+
+// getEnvBreakerOverrides reads comma separated name:setting=value;setting=value entries, e.g.
+// "click-service:failure_threshold=10;reset_timeout=1m". Settings are named like the
+// CIRCUIT_BREAKER_ variables, lower case, and those left out keep their defaults.
+func getEnvBreakerOverrides(key string, defaults BreakerConfig) map[string]BreakerConfig {
+	overrides := make(map[string]BreakerConfig)
+	for _, entry := range getEnvList(key) {
+		name, settings, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			panic(fmt.Sprintf("%s entries must look like name:setting=value;setting=value", key))
+		}
+		breaker := defaults
+		for _, setting := range strings.Split(settings, ";") {
+			if err := breaker.set(strings.TrimSpace(setting)); err != nil {
+				panic(fmt.Sprintf("%s: %s: %v", key, name, err))
+			}
+		}
+		overrides[name] = breaker
+	}
+	return overrides
+}
+
+// set applies one setting=value of a breaker override
+func (b *BreakerConfig) set(setting string) error {
+	if setting == "" {
+		return nil
+	}
+	name, value, ok := strings.Cut(setting, "=")
+	if !ok {
+		return fmt.Errorf("%q must look like setting=value", setting)
+	}
+	var err error
+	switch name {
+	case "failure_threshold":
+		b.FailureThreshold, err = parseCount(value)
+	case "reset_timeout":
+		b.ResetTimeout, err = time.ParseDuration(value)
+	case "max_reset_timeout":
+		b.MaxResetTimeout, err = time.ParseDuration(value)
+	case "failure_rate":
+		b.FailureRate, err = strconv.ParseFloat(value, 64)
+		if err == nil && (b.FailureRate < 0 || b.FailureRate > 1) {
+			err = errors.New("must be from 0 to 1")
+		}
+	case "window":
+		b.Window, err = time.ParseDuration(value)
+	case "min_requests":
+		b.MinRequests, err = parseCount(value)
+	case "half_open_probes":
+		b.HalfOpenProbes, err = parseCount(value)
+	case "slow_call_threshold":
+		b.SlowCallThreshold, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// parseCount parses an integer that can't be negative
+func parseCount(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		err = errors.New("can't be negative")
+	}
+	return n, err
+}
+
 func (c *Config) Parse() {
 	parseError := map[string]string{
 		//!
@@ -99,8 +805,12 @@ func (c *Config) Parse() {
 	parseError[HTTP_PORT] = c.Http.Port
 	//! Logger configs
 	parseError[LOG_FILE] = c.Logger.LogFile
-	//! kafka
-	parseError[KAFKA_BROKER] = c.Kafka.Brokers[0]
+	//! kafka, not needed by the memory event bus
+	if c.EventBus.Backend == BackendKafka {
+		parseError[KAFKA_BROKER] = c.Kafka.Brokers[0]
+	} else {
+		delete(parseError, KAFKA_BROKER)
+	}
 	//! mysql configs
 	parseError[MYSQL_HOST] = c.MySQL.MysqlHost
 	parseError[MYSQL_PORT] = c.MySQL.MysqlPort
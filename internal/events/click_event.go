@@ -0,0 +1,131 @@
+package events
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/pkg/schemaregistry"
+	"github.com/google/uuid"
+)
+
+// ClickEventVersion is the schema version written by this build
+const ClickEventVersion = 1
+
+// ClickEventSubject is the registry subject of the click event schema
+const ClickEventSubject = "ad-clicks-value"
+
+// where a click was recorded
+const (
+	SourceAPI      = "api"
+	SourceRedirect = "redirect"
+	// messages written before the envelope existed
+	SourceLegacyJSON = "legacy-json"
+)
+
+// encodings of published click events
+const (
+	EncodingProtobuf = "protobuf"
+	// the bare click JSON written before the envelope, for consumers that weren't upgraded yet
+	EncodingJSON = "json"
+)
+
+//go:embed click_event.proto
+var clickEventSchema string
+
+// ErrUnknownSchema is returned for a framed message whose schema isn't a click event schema
+var ErrUnknownSchema = errors.New("unknown click event schema")
+
+// ErrInvalidClick is returned for a message that decodes but lacks a click ID or ad ID
+var ErrInvalidClick = errors.New("click event has no click ID or ad ID")
+
+// ClickEvent wraps a click with what is needed to evolve and trace it
+type ClickEvent struct {
+	// 0 for legacy JSON messages
+	SchemaVersion int         `json:"schema_version"`
+	EventID       string      `json:"event_id"`
+	ProducedAt    time.Time   `json:"produced_at"`
+	Source        string      `json:"source"`
+	Click         model.Click `json:"click"`
+}
+
+// NewClickEvent wraps a click that was just recorded by source
+func NewClickEvent(click model.Click, source string) ClickEvent {
+	return ClickEvent{
+		SchemaVersion: ClickEventVersion,
+		EventID:       uuid.NewString(),
+		ProducedAt:    time.Now(),
+		Source:        source,
+		Click:         click,
+	}
+}
+
+// ClickCodec encodes click events as registry framed Protobuf and decodes them as well as the
+// legacy bare click JSON
+type ClickCodec struct {
+	registry schemaregistry.Registry
+	schemaID int
+	encoding string
+}
+
+// NewClickCodec registers the click event schema and returns a codec writing encoding
+func NewClickCodec(registry schemaregistry.Registry, encoding string) (*ClickCodec, error) {
+	if encoding != EncodingProtobuf && encoding != EncodingJSON {
+		return nil, fmt.Errorf("unknown click event encoding %q, use %s or %s", encoding, EncodingProtobuf, EncodingJSON)
+	}
+	schema, err := registry.Register(ClickEventSubject, clickEventSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register click event schema: %w", err)
+	}
+	return &ClickCodec{registry: registry, schemaID: schema.ID, encoding: encoding}, nil
+}
+
+func (c *ClickCodec) Encode(event ClickEvent) ([]byte, error) {
+	if c.encoding == EncodingJSON {
+		return json.Marshal(event.Click)
+	}
+	return schemaregistry.Frame(c.schemaID, marshalClickEvent(event)), nil
+}
+
+// Decode reads a framed Protobuf click event, or a legacy JSON click which gets an envelope
+// with schema version 0 and the click's ID and timestamp. Clicks without an ID or ad ID, like
+// an empty JSON object, fail with ErrInvalidClick.
+func (c *ClickCodec) Decode(data []byte) (ClickEvent, error) {
+	event, err := c.decode(data)
+	if err != nil {
+		return ClickEvent{}, err
+	}
+	if event.Click.ID == "" || event.Click.AdID == "" {
+		return ClickEvent{}, ErrInvalidClick
+	}
+	return event, nil
+}
+
+func (c *ClickCodec) decode(data []byte) (ClickEvent, error) {
+	schemaID, payload, ok := schemaregistry.Unframe(data)
+	if !ok {
+		var click model.Click
+		if err := json.Unmarshal(data, &click); err != nil {
+			return ClickEvent{}, err
+		}
+		return ClickEvent{
+			EventID:    click.ID,
+			ProducedAt: click.Timestamp,
+			Source:     SourceLegacyJSON,
+			Click:      click,
+		}, nil
+	}
+
+	schema, err := c.registry.Lookup(schemaID)
+	if err != nil {
+		return ClickEvent{}, err
+	}
+	if schema.Subject != ClickEventSubject {
+		return ClickEvent{}, fmt.Errorf("%w: %d is a %s schema", ErrUnknownSchema, schemaID, schema.Subject)
+	}
+	// fields are only ever added, so every version decodes with the latest code
+	return unmarshalClickEvent(payload)
+}
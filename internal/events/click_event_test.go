@@ -0,0 +1,252 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/pkg/schemaregistry"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newTestCodec(t *testing.T, encoding string) *ClickCodec {
+	t.Helper()
+	codec, err := NewClickCodec(schemaregistry.NewLocalRegistry(), encoding)
+	if err != nil {
+		t.Fatalf("NewClickCodec: %v", err)
+	}
+	return codec
+}
+
+func testClickEvent() ClickEvent {
+	return ClickEvent{
+		SchemaVersion: ClickEventVersion,
+		EventID:       "7d1f0c8e-2a7b-4f3e-9a51-0b4c7e2f1d11",
+		ProducedAt:    time.Date(2025, 4, 11, 10, 15, 0, 123456789, time.UTC),
+		Source:        SourceRedirect,
+		Click: model.Click{
+			ID:           "000b25f2-ca19-4d7f-ae0a-96ec24356635",
+			AdID:         "2",
+			ImpressionID: "5b1e8d0a-6c1f-4b3a-8f2e-9d7c6b5a4e3f",
+			IP:           "10.0.0.7",
+			PlaybackTime: -5,
+			UserAgent:    "Mozilla/5.0",
+			Referrer:     "https://example.com/ä",
+			Timestamp:    time.Date(2025, 4, 11, 10, 14, 59, 987000000, time.UTC),
+		},
+	}
+}
+
+func assertClickEvent(t *testing.T, got, want ClickEvent) {
+	t.Helper()
+	if got.SchemaVersion != want.SchemaVersion || got.EventID != want.EventID || got.Source != want.Source {
+		t.Errorf("envelope = %d %q %q, want %d %q %q", got.SchemaVersion, got.EventID, got.Source, want.SchemaVersion, want.EventID, want.Source)
+	}
+	if !got.ProducedAt.Equal(want.ProducedAt) {
+		t.Errorf("produced_at = %s, want %s", got.ProducedAt, want.ProducedAt)
+	}
+	g, w := got.Click, want.Click
+	if g.ID != w.ID || g.AdID != w.AdID || g.ImpressionID != w.ImpressionID || g.IP != w.IP ||
+		g.PlaybackTime != w.PlaybackTime || g.UserAgent != w.UserAgent || g.Referrer != w.Referrer {
+		t.Errorf("click = %+v, want %+v", g, w)
+	}
+	if !g.Timestamp.Equal(w.Timestamp) {
+		t.Errorf("timestamp = %s, want %s", g.Timestamp, w.Timestamp)
+	}
+}
+
+func TestClickCodecRoundTrip(t *testing.T) {
+	codec := newTestCodec(t, EncodingProtobuf)
+	event := testClickEvent()
+	data, err := codec.Encode(event)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := codec.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	assertClickEvent(t, decoded, event)
+}
+
+// clickEventDescriptor builds click_event.proto as protoc would, so the hand written codec can
+// be checked against the protobuf runtime
+func clickEventDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	const (
+		str       = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		message   = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		timestamp = ".google.protobuf.Timestamp"
+	)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("click_event_test.proto"),
+		Package:    proto.String("admetric.events.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{timestamppb.File_google_protobuf_timestamp_proto.Path()},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("ClickEvent"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("schema_version", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT32, ""),
+					field("event_id", 2, str, ""),
+					field("produced_at", 3, message, timestamp),
+					field("source", 4, str, ""),
+					field("click", 5, message, ".admetric.events.v1.Click"),
+				},
+			},
+			{
+				Name: proto.String("Click"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, str, ""),
+					field("ad_id", 2, str, ""),
+					field("impression_id", 3, str, ""),
+					field("ip", 4, str, ""),
+					field("playback_time", 5, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					field("user_agent", 6, str, ""),
+					field("referrer", 7, str, ""),
+					field("timestamp", 8, message, timestamp),
+				},
+			},
+		},
+	}
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("building descriptor: %v", err)
+	}
+	return fd.Messages().ByName("ClickEvent")
+}
+
+func TestClickEventMatchesProtobufRuntime(t *testing.T) {
+	descriptor := clickEventDescriptor(t)
+	event := testClickEvent()
+
+	// what the codec writes, read by the protobuf runtime
+	msg := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(marshalClickEvent(event), msg); err != nil {
+		t.Fatalf("proto.Unmarshal: %v", err)
+	}
+	fields := descriptor.Fields()
+	if got := msg.Get(fields.ByName("schema_version")).Uint(); got != uint64(event.SchemaVersion) {
+		t.Errorf("schema_version = %d, want %d", got, event.SchemaVersion)
+	}
+	if got := msg.Get(fields.ByName("event_id")).String(); got != event.EventID {
+		t.Errorf("event_id = %q, want %q", got, event.EventID)
+	}
+	click := msg.Get(fields.ByName("click")).Message()
+	clickFields := click.Descriptor().Fields()
+	if got := click.Get(clickFields.ByName("playback_time")).Int(); got != int64(event.Click.PlaybackTime) {
+		t.Errorf("playback_time = %d, want %d", got, event.Click.PlaybackTime)
+	}
+	if got := click.Get(clickFields.ByName("referrer")).String(); got != event.Click.Referrer {
+		t.Errorf("referrer = %q, want %q", got, event.Click.Referrer)
+	}
+	ts := click.Get(clickFields.ByName("timestamp")).Message()
+	tsFields := ts.Descriptor().Fields()
+	got := time.Unix(ts.Get(tsFields.ByName("seconds")).Int(), ts.Get(tsFields.ByName("nanos")).Int())
+	if !got.Equal(event.Click.Timestamp) {
+		t.Errorf("timestamp = %s, want %s", got, event.Click.Timestamp)
+	}
+
+	// what the protobuf runtime writes, read by the codec
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("proto.Marshal: %v", err)
+	}
+	decoded, err := unmarshalClickEvent(data)
+	if err != nil {
+		t.Fatalf("unmarshalClickEvent: %v", err)
+	}
+	assertClickEvent(t, decoded, event)
+}
+
+func TestClickCodecSkipsUnknownFields(t *testing.T) {
+	event := testClickEvent()
+	// a newer schema with a field this build doesn't know
+	data := appendString(marshalClickEvent(event), 99, "from the future")
+	decoded, err := unmarshalClickEvent(data)
+	if err != nil {
+		t.Fatalf("unmarshalClickEvent: %v", err)
+	}
+	assertClickEvent(t, decoded, event)
+}
+
+func TestClickCodecDecodesLegacyJSON(t *testing.T) {
+	event := testClickEvent()
+	data, err := newTestCodec(t, EncodingJSON).Encode(event)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	// written by the json encoding, read by a protobuf codec
+	decoded, err := newTestCodec(t, EncodingProtobuf).Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := ClickEvent{
+		EventID:    event.Click.ID,
+		ProducedAt: event.Click.Timestamp,
+		Source:     SourceLegacyJSON,
+		Click:      event.Click,
+	}
+	assertClickEvent(t, decoded, want)
+}
+
+func TestClickCodecRejectsInvalidClicks(t *testing.T) {
+	codec := newTestCodec(t, EncodingProtobuf)
+	for name, data := range map[string][]byte{
+		"empty object":  []byte(`{}`),
+		"missing ad ID": []byte(`{"id":"000b25f2-ca19-4d7f-ae0a-96ec24356635"}`),
+		"missing ID":    []byte(`{"ad_id":"2"}`),
+	} {
+		if _, err := codec.Decode(data); !errors.Is(err, ErrInvalidClick) {
+			t.Errorf("%s: Decode error = %v, want ErrInvalidClick", name, err)
+		}
+	}
+
+	empty, err := codec.Encode(ClickEvent{SchemaVersion: ClickEventVersion})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if _, err := codec.Decode(empty); !errors.Is(err, ErrInvalidClick) {
+		t.Errorf("empty protobuf click: Decode error = %v, want ErrInvalidClick", err)
+	}
+
+	if _, err := codec.Decode([]byte("not json")); err == nil {
+		t.Error("Decode accepted a malformed message")
+	}
+}
+
+func TestClickCodecRejectsOtherSchemas(t *testing.T) {
+	registry := schemaregistry.NewLocalRegistry()
+	codec, err := NewClickCodec(registry, EncodingProtobuf)
+	if err != nil {
+		t.Fatalf("NewClickCodec: %v", err)
+	}
+	other, err := registry.Register("ad-impressions-value", `syntax = "proto3";`)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	data := schemaregistry.Frame(other.ID, marshalClickEvent(testClickEvent()))
+	if _, err := codec.Decode(data); !errors.Is(err, ErrUnknownSchema) {
+		t.Errorf("Decode error = %v, want ErrUnknownSchema", err)
+	}
+}
@@ -0,0 +1,176 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The click event messages are small and stable, so they are encoded by hand along
+// click_event.proto instead of generating code. Zero values are left out as in proto3.
+
+func marshalClickEvent(event ClickEvent) []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(event.SchemaVersion))
+	b = appendString(b, 2, event.EventID)
+	b = appendTimestamp(b, 3, event.ProducedAt)
+	b = appendString(b, 4, event.Source)
+	b = appendMessage(b, 5, marshalClick(event.Click))
+	return b
+}
+
+func marshalClick(click model.Click) []byte {
+	var b []byte
+	b = appendString(b, 1, click.ID)
+	b = appendString(b, 2, click.AdID)
+	b = appendString(b, 3, click.ImpressionID)
+	b = appendString(b, 4, click.IP)
+	b = appendVarint(b, 5, uint64(int32(click.PlaybackTime)))
+	b = appendString(b, 6, click.UserAgent)
+	b = appendString(b, 7, click.Referrer)
+	b = appendTimestamp(b, 8, click.Timestamp)
+	return b
+}
+
+func unmarshalClickEvent(b []byte) (ClickEvent, error) {
+	var event ClickEvent
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			event.SchemaVersion = int(v)
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			return consumeString(b, &event.EventID)
+		case num == 3 && typ == protowire.BytesType:
+			return consumeTimestamp(b, &event.ProducedAt)
+		case num == 4 && typ == protowire.BytesType:
+			return consumeString(b, &event.Source)
+		case num == 5 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			click, err := unmarshalClick(v)
+			event.Click = click
+			return n, err
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if err != nil {
+		return ClickEvent{}, fmt.Errorf("failed to decode click event: %w", err)
+	}
+	return event, nil
+}
+
+func unmarshalClick(b []byte) (model.Click, error) {
+	var click model.Click
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return consumeString(b, &click.ID)
+		case num == 2 && typ == protowire.BytesType:
+			return consumeString(b, &click.AdID)
+		case num == 3 && typ == protowire.BytesType:
+			return consumeString(b, &click.ImpressionID)
+		case num == 4 && typ == protowire.BytesType:
+			return consumeString(b, &click.IP)
+		case num == 5 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			click.PlaybackTime = int(int32(v))
+			return n, nil
+		case num == 6 && typ == protowire.BytesType:
+			return consumeString(b, &click.UserAgent)
+		case num == 7 && typ == protowire.BytesType:
+			return consumeString(b, &click.Referrer)
+		case num == 8 && typ == protowire.BytesType:
+			return consumeTimestamp(b, &click.Timestamp)
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return click, err
+}
+
+// consumeFields calls field for every field of a message, which consumes the field's value and
+// returns its length. Unknown fields are skipped by field too, so newer schemas still decode.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeString(b []byte, s *string) (int, error) {
+	v, n := protowire.ConsumeString(b)
+	*s = v
+	return n, nil
+}
+
+// consumeTimestamp reads a google.protobuf.Timestamp
+func consumeTimestamp(b []byte, t *time.Time) (int, error) {
+	v, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n, nil
+	}
+	var seconds, nanos int64
+	err := consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ != protowire.VarintType || (num != 1 && num != 2) {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeVarint(b)
+		if num == 1 {
+			seconds = int64(v)
+		} else {
+			nanos = int64(int32(v))
+		}
+		return n, nil
+	})
+	*t = time.Unix(seconds, nanos)
+	return n, err
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// appendTimestamp writes t as a google.protobuf.Timestamp, leaving out the zero time
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var m []byte
+	m = appendVarint(m, 1, uint64(t.Unix()))
+	m = appendVarint(m, 2, uint64(t.Nanosecond()))
+	return appendMessage(b, num, m)
+}
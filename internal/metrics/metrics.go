@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// stream label values
+const (
+	StreamClicks      = "clicks"
+	StreamImpressions = "impressions"
+)
+
+// result label values
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// DuplicatesDropped counts consumed messages skipped because their ID was already processed
+var DuplicatesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "admetric_duplicates_dropped_total",
+	Help: "Consumed messages dropped because their ID was already processed.",
+}, []string{"stream"})
+
+// KafkaPublished counts published messages by topic and whether the brokers acknowledged them
+var KafkaPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "admetric_kafka_published_total",
+	Help: "Messages published to Kafka by topic and result.",
+}, []string{"topic", "result"})
+
+// KafkaProducerQueued is how many messages the producer holds unacknowledged
+var KafkaProducerQueued = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "admetric_kafka_producer_queued",
+	Help: "Messages queued in the Kafka producer and not yet acknowledged.",
+})
+
+// KafkaProducerRejected counts messages turned away because the producer queue was full
+var KafkaProducerRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "admetric_kafka_producer_rejected_total",
+	Help: "Messages rejected because the Kafka producer queue was full.",
+}, []string{"topic"})
+
+// HTTPRequests counts handled HTTP requests by route pattern, method and status code
+var HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "admetric_http_requests_total",
+	Help: "HTTP requests by route, method and status code.",
+}, []string{"route", "method", "status"})
+
+// HTTPRequestDuration is how long handling an HTTP request took
+var HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "admetric_http_request_duration_seconds",
+	Help:    "Time spent handling HTTP requests by route and method.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route", "method"})
+
+// KafkaPublishDuration is how long the brokers took to acknowledge a message, for async
+// publishes counted from when it was queued
+var KafkaPublishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "admetric_kafka_publish_duration_seconds",
+	Help:    "Time from publishing a message to Kafka until the brokers acknowledged or rejected it.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"topic"})
+
+// ConsumerLag is how many messages a consumer group is behind on a partition, as of the last
+// message it received
+var ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "admetric_consumer_lag",
+	Help: "Messages a consumer group has yet to receive by topic and partition.",
+}, []string{"group", "topic", "partition"})
+
+// BatchSize is how many messages a batch held when it was flushed
+var BatchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "admetric_batch_size",
+	Help:    "Messages per flushed batch.",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+}, []string{"stream"})
+
+// BatchFlushDuration is how long writing a batch took
+var BatchFlushDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "admetric_batch_flush_duration_seconds",
+	Help:    "Time spent flushing a batch.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"stream"})
+
+// circuitBreakers reports the state and trip count of the breakers of a registry at scrape time
+type circuitBreakers struct {
+	registry *circuitbreaker.Registry
+	state    *prometheus.Desc
+	trips    *prometheus.Desc
+}
+
+// RegisterCircuitBreakers reports every breaker of registry by name
+func RegisterCircuitBreakers(registry *circuitbreaker.Registry) {
+	prometheus.MustRegister(&circuitBreakers{
+		registry: registry,
+		state: prometheus.NewDesc("admetric_circuit_breaker_state",
+			"State of a circuit breaker: 0 closed, 1 open, 2 half-open.", []string{"name"}, nil),
+		trips: prometheus.NewDesc("admetric_circuit_breaker_trips_total",
+			"Times failures opened a circuit breaker.", []string{"name"}, nil),
+	})
+}
+
+func (c *circuitBreakers) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.trips
+}
+
+func (c *circuitBreakers) Collect(ch chan<- prometheus.Metric) {
+	for _, cb := range c.registry.All() {
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, float64(cb.State()), cb.Name())
+		ch <- prometheus.MustNewConstMetric(c.trips, prometheus.CounterValue, float64(cb.Trips()), cb.Name())
+	}
+}
@@ -8,11 +8,75 @@ import (
 
 type Ad struct {
     ID          string         `gorm:"type:char(36);primaryKey;column:id" json:"id"`
+    CampaignID  *string        `gorm:"type:char(36);index;column:campaign_id" json:"campaign_id"`
     ImageURL    string         `gorm:"type:varchar(2048);not null;column:image_url" json:"image_url"` // URLs need more space
     TargetURL   string         `gorm:"type:varchar(2048);not null;column:target_url" json:"target_url"`
+    UTMSource   string         `gorm:"type:varchar(255);column:utm_source" json:"utm_source,omitempty"` // appended to TargetURL on redirect
+    UTMMedium   string         `gorm:"type:varchar(255);column:utm_medium" json:"utm_medium,omitempty"`
+    UTMCampaign string         `gorm:"type:varchar(255);column:utm_campaign" json:"utm_campaign,omitempty"`
+    UTMTerm     string         `gorm:"type:varchar(255);column:utm_term" json:"utm_term,omitempty"`
+    UTMContent  string         `gorm:"type:varchar(255);column:utm_content" json:"utm_content,omitempty"`
     CreatedAt   time.Time      `gorm:"autoCreateTime;column:created_at" json:"created_at"`
     UpdatedAt   time.Time      `gorm:"autoUpdateTime;column:updated_at" json:"updated_at"`
     DeletedAt   gorm.DeletedAt `gorm:"index;column:deleted_at" json:"deleted_at"`
-    Clicks      []Click        `gorm:"foreignKey:AdID"` // No column needed (relationship)
+    Clicks      []Click        `gorm:"foreignKey:AdID" json:"Clicks,omitempty"` // No column needed (relationship)
     TotalClicks int            `gorm:"column:total_clicks;not null;default:0" json:"total_clicks"`
-}
\ No newline at end of file
+}
+
+// AdInput is the request body for creating an ad and for patching one;
+// nil fields are left untouched on patch
+type AdInput struct {
+    CampaignID  *string `json:"campaign_id"`
+    ImageURL    *string `json:"image_url"`
+    TargetURL   *string `json:"target_url"`
+    UTMSource   *string `json:"utm_source"`
+    UTMMedium   *string `json:"utm_medium"`
+    UTMCampaign *string `json:"utm_campaign"`
+    UTMTerm     *string `json:"utm_term"`
+    UTMContent  *string `json:"utm_content"`
+}
+
+// UTMParams returns the ad's non-empty UTM parameters keyed by query parameter name
+func (a Ad) UTMParams() map[string]string {
+    params := make(map[string]string)
+    for key, value := range map[string]string{
+        "utm_source":   a.UTMSource,
+        "utm_medium":   a.UTMMedium,
+        "utm_campaign": a.UTMCampaign,
+        "utm_term":     a.UTMTerm,
+        "utm_content":  a.UTMContent,
+    } {
+        if value != "" {
+            params[key] = value
+        }
+    }
+    return params
+}
+
+
+// AdListQuery holds the sorting, filtering and paging options for listing ads
+type AdListQuery struct {
+    SortBy         string // created_at or total_clicks
+    CampaignID     string
+    Desc           bool
+    Cursor         string
+    PageSize       int
+    CreatedFrom    *time.Time
+    CreatedTo      *time.Time
+    HasClicks      *bool
+    IncludeDeleted bool
+    RecentClicks   int // 0 means clicks are not loaded
+}
+
+// Paging is returned alongside a page of results
+type Paging struct {
+    PageSize   int    `json:"page_size"`
+    Count      int    `json:"count"`
+    HasMore    bool   `json:"has_more"`
+    NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type AdPage struct {
+    Ads    []Ad   `json:"ads"`
+    Paging Paging `json:"paging"`
+}
@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Advertiser struct {
+    ID        string         `gorm:"type:char(36);primaryKey;column:id" json:"id"`
+    Name      string         `gorm:"type:varchar(255);not null;column:name" json:"name"`
+    Email     string         `gorm:"type:varchar(320);column:email" json:"email"`
+    CreatedAt time.Time      `gorm:"autoCreateTime;column:created_at" json:"created_at"`
+    UpdatedAt time.Time      `gorm:"autoUpdateTime;column:updated_at" json:"updated_at"`
+    DeletedAt gorm.DeletedAt `gorm:"index;column:deleted_at" json:"deleted_at"`
+    Campaigns []Campaign     `gorm:"foreignKey:AdvertiserID" json:"campaigns,omitempty"` // No column needed (relationship)
+}
+
+// AdvertiserInput is the request body for creating and patching an advertiser
+type AdvertiserInput struct {
+    Name  *string `json:"name"`
+    Email *string `json:"email"`
+}
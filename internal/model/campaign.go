@@ -0,0 +1,66 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+    CampaignStatusDraft     = "draft"
+    CampaignStatusActive    = "active"
+    CampaignStatusPaused    = "paused"
+    CampaignStatusCompleted = "completed"
+)
+
+type Campaign struct {
+    ID           string         `gorm:"type:char(36);primaryKey;column:id" json:"id"`
+    AdvertiserID string         `gorm:"type:char(36);not null;index;column:advertiser_id" json:"advertiser_id"`
+    Name         string         `gorm:"type:varchar(255);not null;column:name" json:"name"`
+    Budget       Money          `gorm:"type:decimal(14,2);not null;default:0;column:budget" json:"budget"`
+    StartDate    *time.Time     `gorm:"column:start_date" json:"start_date"`
+    EndDate      *time.Time     `gorm:"column:end_date" json:"end_date"`
+    Status       string         `gorm:"type:varchar(16);not null;default:draft;column:status" json:"status"`
+    CreatedAt    time.Time      `gorm:"autoCreateTime;column:created_at" json:"created_at"`
+    UpdatedAt    time.Time      `gorm:"autoUpdateTime;column:updated_at" json:"updated_at"`
+    DeletedAt    gorm.DeletedAt `gorm:"index;column:deleted_at" json:"deleted_at"`
+    Ads          []Ad           `gorm:"foreignKey:CampaignID" json:"ads,omitempty"` // No column needed (relationship)
+}
+
+// CampaignInput is the request body for creating and patching a campaign
+type CampaignInput struct {
+    AdvertiserID *string      `json:"advertiser_id"`
+    Name         *string      `json:"name"`
+    Budget       *Money       `json:"budget"`
+    StartDate    OptionalTime `json:"start_date"` // null clears it
+    EndDate      OptionalTime `json:"end_date"`   // null clears it
+    Status       *string      `json:"status"`
+}
+
+// OptionalTime is a time in a patch body that tells a missing field, left untouched, from an
+// explicit null, which clears it
+type OptionalTime struct {
+    Set  bool
+    Time *time.Time
+}
+
+func (o *OptionalTime) UnmarshalJSON(data []byte) error {
+    o.Set = true
+    if string(data) == "null" {
+        o.Time = nil
+        return nil
+    }
+    var t time.Time
+    if err := json.Unmarshal(data, &t); err != nil {
+        return err
+    }
+    o.Time = &t
+    return nil
+}
+
+// ClickStats is the click activity of an ad, campaign or advertiser
+type ClickStats struct {
+    Clicks      int64 `json:"clicks"`       // clicks within the requested timeframe
+    TotalClicks int64 `json:"total_clicks"` // lifetime clicks
+}
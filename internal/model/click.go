@@ -4,9 +4,15 @@ import "time"
 
 type Click struct {
     ID           string    `gorm:"type:char(36);primaryKey;column:id" json:"id"`
-    AdID         string    `gorm:"type:char(36);not null;column:ad_id" json:"ad_id"`
+    AdID         string    `gorm:"type:char(36);not null;index:idx_click_ip_ad_time,priority:2;index:idx_click_ad_time,priority:1;column:ad_id" json:"ad_id"`
     Ad           Ad        `gorm:"foreignKey:AdID;references:ID"` // No column needed
-    IP           string    `gorm:"type:varchar(45);not null;column:ip" json:"ip"` // Changed to varchar(45)
+    ImpressionID string    `gorm:"type:char(36);column:impression_id" json:"impression_id"` // from the click token, empty when signing is off
+    IP           string    `gorm:"type:varchar(45);not null;index:idx_click_ip_time,priority:1;index:idx_click_ip_ad_time,priority:1;column:ip" json:"ip"` // Changed to varchar(45)
     PlaybackTime int       `gorm:"not null;column:playback_time" json:"playback_time"`
-    Timestamp    time.Time `gorm:"not null;column:timestamp" json:"timestamp"`
+    UserAgent    string    `gorm:"type:varchar(512);column:user_agent" json:"user_agent"`
+    Referrer     string    `gorm:"type:varchar(2048);column:referrer" json:"referrer"`
+    Timestamp    time.Time `gorm:"not null;index:idx_click_ip_time,priority:2;index:idx_click_ip_ad_time,priority:3;index:idx_click_ad_time,priority:2;index:idx_click_time;column:timestamp" json:"timestamp"`
+    FraudScore   float64   `gorm:"not null;default:0;column:fraud_score" json:"fraud_score"`
+    Flagged      bool      `gorm:"not null;default:false;index;column:flagged" json:"flagged"` // flagged clicks are kept but not counted
+    FraudReason  string    `gorm:"type:varchar(512);column:fraud_reason" json:"fraud_reason,omitempty"`
 }
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// ClickRollup is the pre-aggregated unflagged click activity of an ad in one UTC aligned bucket
+type ClickRollup struct {
+    AdID        string    `gorm:"type:char(36);primaryKey;column:ad_id" json:"ad_id"`
+    BucketStart time.Time `gorm:"primaryKey;column:bucket_start" json:"bucket_start"`
+    Clicks      int64     `gorm:"not null;default:0;column:clicks" json:"clicks"`
+    PlaybackSum int64     `gorm:"not null;default:0;column:playback_sum" json:"playback_sum"`
+    UniqueIPs   int64     `gorm:"not null;default:0;column:unique_ips" json:"unique_ips"`
+}
+
+type ClickRollupMinute struct {
+    ClickRollup
+}
+
+type ClickRollupHour struct {
+    ClickRollup
+}
+
+type ClickRollupDay struct {
+    ClickRollup
+}
+
+// ClickRollupWatermark records up to when a rollup table has been compacted;
+// everything before CompactedUntil can be read from the rollup instead of raw clicks
+type ClickRollupWatermark struct {
+    Granularity    string    `gorm:"type:varchar(16);primaryKey;column:granularity" json:"granularity"`
+    CompactedUntil time.Time `gorm:"not null;column:compacted_until" json:"compacted_until"`
+}
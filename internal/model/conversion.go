@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// Conversion is a post-click event such as a signup or purchase. It is attributed to the ad
+// of the referenced click when it happens within the attribution window after that click.
+type Conversion struct {
+    ID          string    `gorm:"type:char(36);primaryKey;column:id" json:"id"`
+    ClickID     string    `gorm:"type:char(36);not null;index;column:click_id" json:"click_id"`
+    Click       Click     `gorm:"foreignKey:ClickID;references:ID" json:"-"` // No column needed
+    Type        string    `gorm:"type:varchar(64);not null;column:type" json:"type"`
+    Value       float64   `gorm:"type:decimal(14,2);not null;default:0;column:value" json:"value"`
+    ConvertedAt time.Time `gorm:"not null;index;column:converted_at" json:"converted_at"`
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// DeadLetter is a message that was given up on and parked in a dead-letter topic
+type DeadLetter struct {
+    Partition int32     `json:"partition"`
+    Offset    int64     `json:"offset"`
+    Key       string    `json:"key"` // the ad ID, empty when the message didn't parse
+    Timestamp time.Time `json:"timestamp"`
+    Attempts  int       `json:"attempts"`
+    Error     string    `json:"error"`
+    Value     string    `json:"value"` // the click event as JSON, or the original message when it doesn't decode
+}
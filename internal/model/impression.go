@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+type Impression struct {
+    ID        string    `gorm:"type:char(36);primaryKey;column:id" json:"id"`
+    AdID      string    `gorm:"type:char(36);not null;index:idx_impression_ad_time,priority:1;column:ad_id" json:"ad_id"`
+    IP        string    `gorm:"type:varchar(45);not null;column:ip" json:"ip"`
+    Timestamp time.Time `gorm:"not null;index:idx_impression_ad_time,priority:2;column:timestamp" json:"timestamp"`
+}
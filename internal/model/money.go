@@ -0,0 +1,108 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxMoney is the largest amount a decimal(14,2) column holds
+const MaxMoney Money = 99999999999999
+
+// Money is an amount in cents. It is read from and written to JSON and decimal(14,2) columns as
+// a number with two decimals, e.g. 2500.00, without going through a float.
+type Money int64
+
+// ParseMoney parses an amount with at most two decimals, e.g. "2500", "2500.5" or "-0.25"
+func ParseMoney(s string) (Money, error) {
+    invalid := fmt.Errorf("invalid amount %q", s)
+    sign := int64(1)
+    digits := s
+    if strings.HasPrefix(digits, "-") {
+        sign = -1
+        digits = digits[1:]
+    }
+    whole, frac, hasFrac := strings.Cut(digits, ".")
+    if whole == "" || len(frac) > 2 || (hasFrac && frac == "") || !isDigits(whole) || !isDigits(frac) {
+        return 0, invalid
+    }
+    units, err := strconv.ParseInt(whole, 10, 64)
+    if err != nil || units > int64(MaxMoney)/100 {
+        return 0, invalid
+    }
+    cents := units * 100
+    if frac != "" {
+        n, _ := strconv.ParseInt(frac, 10, 64)
+        if len(frac) == 1 {
+            n *= 10
+        }
+        cents += n
+    }
+    return Money(sign * cents), nil
+}
+
+func isDigits(s string) bool {
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+func (m Money) String() string {
+    sign := ""
+    cents := int64(m)
+    if cents < 0 {
+        sign = "-"
+        cents = -cents
+    }
+    return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+    return []byte(m.String()), nil
+}
+
+// UnmarshalJSON takes a JSON number, rejecting one with more than two decimals or an exponent
+func (m *Money) UnmarshalJSON(data []byte) error {
+    parsed, err := ParseMoney(string(data))
+    if err != nil {
+        return err
+    }
+    *m = parsed
+    return nil
+}
+
+// Scan reads a decimal column, which the MySQL driver returns as text
+func (m *Money) Scan(value interface{}) error {
+    switch v := value.(type) {
+    case []byte:
+        return m.scanText(string(v))
+    case string:
+        return m.scanText(v)
+    case int64:
+        *m = Money(v * 100)
+        return nil
+    case nil:
+        *m = 0
+        return nil
+    }
+    return errors.New("unsupported type for money")
+}
+
+func (m *Money) scanText(s string) error {
+    parsed, err := ParseMoney(s)
+    if err != nil {
+        return err
+    }
+    *m = parsed
+    return nil
+}
+
+// Value writes the amount as decimal text so the column stores it exactly
+func (m Money) Value() (driver.Value, error) {
+    return m.String(), nil
+}
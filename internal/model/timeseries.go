@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// ClickBucket is the click activity of an ad within one time series bucket
+type ClickBucket struct {
+    Start           time.Time `json:"start"`
+    Clicks          int64     `json:"clicks"`
+    AvgPlaybackTime float64   `json:"avg_playback_time"`
+    UniqueIPs       int64     `json:"unique_ips"`
+}
@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/ArjunMalhotra/internal/model"
@@ -15,14 +16,150 @@ func NewAdRepository(db *gorm.DB) *AdRepo {
 	return &AdRepo{db: db}
 }
 
-func (r *AdRepo) FetchAll() ([]model.Ad, error) {
+// AdCursor is the position of the last ad on the previous page
+type AdCursor struct {
+	Value interface{}
+	ID    string
+}
+
+// List returns up to limit ads matching the query, starting after the cursor.
+// Ads are ordered by the sort column with the ID as a tiebreaker so the keyset is stable.
+func (r *AdRepo) List(q model.AdListQuery, after *AdCursor, limit int) ([]model.Ad, error) {
+	query := r.db.Model(&model.Ad{})
+	if q.IncludeDeleted {
+		query = query.Unscoped()
+	}
+	if q.CampaignID != "" {
+		query = query.Where("campaign_id = ?", q.CampaignID)
+	}
+	if q.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *q.CreatedFrom)
+	}
+	if q.CreatedTo != nil {
+		query = query.Where("created_at < ?", *q.CreatedTo)
+	}
+	if q.HasClicks != nil {
+		if *q.HasClicks {
+			query = query.Where("total_clicks > 0")
+		} else {
+			query = query.Where("total_clicks = 0")
+		}
+	}
+	// sort column is whitelisted by the service, never taken from raw input
+	cmp, dir := ">", "ASC"
+	if q.Desc {
+		cmp, dir = "<", "DESC"
+	}
+	if after != nil {
+		query = query.Where(
+			fmt.Sprintf("(%[1]s %[2]s ?) OR (%[1]s = ? AND id %[2]s ?)", q.SortBy, cmp),
+			after.Value, after.Value, after.ID,
+		)
+	}
 	var ads []model.Ad
-	if err := r.db.Preload("Clicks").Find(&ads).Error; err != nil {
+	err := query.
+		Order(fmt.Sprintf("%s %s, id %s", q.SortBy, dir, dir)).
+		Limit(limit).
+		Find(&ads).Error
+	if err != nil {
 		return nil, err
 	}
 	return ads, nil
 }
 
+// FetchByCampaigns returns the ID, campaign and total clicks of every live ad in the campaigns
+func (r *AdRepo) FetchByCampaigns(campaignIDs []string) ([]model.Ad, error) {
+	var ads []model.Ad
+	if len(campaignIDs) == 0 {
+		return ads, nil
+	}
+	err := r.db.Select("id", "campaign_id", "total_clicks").
+		Where("campaign_id IN ?", campaignIDs).
+		Find(&ads).Error
+	if err != nil {
+		return nil, err
+	}
+	return ads, nil
+}
+
+// FetchRecentClicks returns the latest n clicks of each ad keyed by ad ID
+func (r *AdRepo) FetchRecentClicks(adIDs []string, n int) (map[string][]model.Click, error) {
+	result := make(map[string][]model.Click, len(adIDs))
+	if len(adIDs) == 0 || n <= 0 {
+		return result, nil
+	}
+	ranked := r.db.Model(&model.Click{}).
+		Select("*, ROW_NUMBER() OVER (PARTITION BY ad_id ORDER BY timestamp DESC) AS rn").
+		Where("ad_id IN ? AND flagged = ?", adIDs, false)
+	var clicks []model.Click
+	err := r.db.Table("(?) AS ranked", ranked).
+		Where("rn <= ?", n).
+		Order("ad_id, timestamp DESC").
+		Find(&clicks).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, click := range clicks {
+		result[click.AdID] = append(result[click.AdID], click)
+	}
+	return result, nil
+}
+
+// FetchByID returns a single ad. Soft-deleted ads are only returned when unscoped is set.
+func (r *AdRepo) FetchByID(id string, unscoped bool) (*model.Ad, error) {
+	var ad model.Ad
+	query := r.db
+	if unscoped {
+		query = query.Unscoped()
+	}
+	if err := query.Where("id = ?", id).First(&ad).Error; err != nil {
+		return nil, err
+	}
+	return &ad, nil
+}
+
+func (r *AdRepo) Create(ad *model.Ad) error {
+	return r.db.Create(ad).Error
+}
+
+// Update writes the given columns for a live (not soft-deleted) ad
+func (r *AdRepo) Update(id string, fields map[string]interface{}) error {
+	result := r.db.Model(&model.Ad{}).Where("id = ?", id).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete soft deletes an ad by setting deleted_at
+func (r *AdRepo) Delete(id string) error {
+	result := r.db.Where("id = ?", id).Delete(&model.Ad{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Restore clears deleted_at on a soft-deleted ad
+func (r *AdRepo) Restore(id string) error {
+	result := r.db.Unscoped().Model(&model.Ad{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (r *AdRepo) CountAds() (int, error) {
 	var count int64
 	if err := r.db.Model(&model.Ad{}).Count(&count).Error; err != nil {
@@ -30,4 +167,4 @@ func (r *AdRepo) CountAds() (int, error) {
 		return 0, err
 	}
 	return int(count), nil
-}
\ No newline at end of file
+}
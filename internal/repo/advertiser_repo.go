@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"github.com/ArjunMalhotra/internal/model"
+	"gorm.io/gorm"
+)
+
+type AdvertiserRepo struct {
+	db *gorm.DB
+}
+
+func NewAdvertiserRepo(db *gorm.DB) *AdvertiserRepo {
+	return &AdvertiserRepo{db: db}
+}
+
+func (r *AdvertiserRepo) Create(advertiser *model.Advertiser) error {
+	return r.db.Create(advertiser).Error
+}
+
+func (r *AdvertiserRepo) FetchAll() ([]model.Advertiser, error) {
+	var advertisers []model.Advertiser
+	if err := r.db.Order("created_at DESC").Find(&advertisers).Error; err != nil {
+		return nil, err
+	}
+	return advertisers, nil
+}
+
+func (r *AdvertiserRepo) FetchByID(id string) (*model.Advertiser, error) {
+	var advertiser model.Advertiser
+	if err := r.db.Where("id = ?", id).First(&advertiser).Error; err != nil {
+		return nil, err
+	}
+	return &advertiser, nil
+}
+
+func (r *AdvertiserRepo) Update(id string, fields map[string]interface{}) error {
+	result := r.db.Model(&model.Advertiser{}).Where("id = ?", id).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete soft deletes an advertiser, its campaigns are left untouched
+func (r *AdvertiserRepo) Delete(id string) error {
+	result := r.db.Where("id = ?", id).Delete(&model.Advertiser{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
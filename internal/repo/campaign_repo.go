@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"github.com/ArjunMalhotra/internal/model"
+	"gorm.io/gorm"
+)
+
+type CampaignRepo struct {
+	db *gorm.DB
+}
+
+func NewCampaignRepo(db *gorm.DB) *CampaignRepo {
+	return &CampaignRepo{db: db}
+}
+
+func (r *CampaignRepo) Create(campaign *model.Campaign) error {
+	return r.db.Create(campaign).Error
+}
+
+// FetchAll returns campaigns, optionally narrowed to one advertiser and/or status
+func (r *CampaignRepo) FetchAll(advertiserID, status string) ([]model.Campaign, error) {
+	query := r.db.Model(&model.Campaign{})
+	if advertiserID != "" {
+		query = query.Where("advertiser_id = ?", advertiserID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var campaigns []model.Campaign
+	if err := query.Order("created_at DESC").Find(&campaigns).Error; err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+func (r *CampaignRepo) FetchByID(id string) (*model.Campaign, error) {
+	var campaign model.Campaign
+	if err := r.db.Where("id = ?", id).First(&campaign).Error; err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+func (r *CampaignRepo) FetchIDsByAdvertiser(advertiserID string) ([]string, error) {
+	var ids []string
+	err := r.db.Model(&model.Campaign{}).
+		Where("advertiser_id = ?", advertiserID).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *CampaignRepo) Update(id string, fields map[string]interface{}) error {
+	result := r.db.Model(&model.Campaign{}).Where("id = ?", id).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete soft deletes a campaign, its ads keep their campaign_id
+func (r *CampaignRepo) Delete(id string) error {
+	result := r.db.Where("id = ?", id).Delete(&model.Campaign{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package repo
 
 import (
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ArjunMalhotra/internal/model"
@@ -16,26 +19,79 @@ func NewClickRepo(db *gorm.DB) *ClickRepo {
 	return &ClickRepo{DB: db}
 }
 
-func (r *ClickRepo) SaveBatch(clicks []model.Click) error {
-	if err := r.DB.CreateInBatches(&clicks, 500).Error; err != nil {
-		log.Printf("Failed to save click event: %v", err)
-		return err
+// SaveBatch stores the clicks that aren't stored yet and adds the unflagged ones to the
+// total_clicks of their ads in a single transaction, so a batch that is retried or redelivered
+// is never counted twice. It returns the clicks that were new and the total_clicks the
+// transaction left their ads with.
+func (r *ClickRepo) SaveBatch(clicks []model.Click) ([]model.Click, map[string]int, error) {
+	ids := make([]string, len(clicks))
+	for i, click := range clicks {
+		ids[i] = click.ID
 	}
-	return nil
-}
 
-func (r *ClickRepo) UpdateAdTotalClicks(adID string, increment int) error {
-	result := r.DB.Model(&model.Ad{}).
-		Where("id = ?", adID).
-		UpdateColumn("total_clicks", gorm.Expr("total_clicks + ?", increment))
+	var inserted []model.Click
+	var totals map[string]int
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var existing []string
+		if err := tx.Model(&model.Click{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		skip := make(map[string]bool, len(clicks))
+		for _, id := range existing {
+			skip[id] = true
+		}
+		inserted = inserted[:0]
+		totals = make(map[string]int)
+		increments := make(map[string]int64)
+		for _, click := range clicks {
+			if skip[click.ID] {
+				continue
+			}
+			skip[click.ID] = true // the same click twice in one batch
+			inserted = append(inserted, click)
+			if !click.Flagged {
+				increments[click.AdID]++
+			}
+		}
+		if len(inserted) == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(&inserted, 500).Error; err != nil {
+			return err
+		}
 
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+		// update ads in a fixed order so concurrent batches can't deadlock on each other
+		adIDs := make([]string, 0, len(increments))
+		for adID := range increments {
+			adIDs = append(adIDs, adID)
+		}
+		sort.Strings(adIDs)
+		for _, adID := range adIDs {
+			err := tx.Model(&model.Ad{}).
+				Where("id = ?", adID).
+				UpdateColumn("total_clicks", gorm.Expr("total_clicks + ?", increments[adID])).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(adIDs) == 0 {
+			return nil
+		}
+		// the rows stay locked until the commit, so these are the totals as committed
+		var ads []model.Ad
+		if err := tx.Select("id", "total_clicks").Where("id IN ?", adIDs).Find(&ads).Error; err != nil {
+			return err
+		}
+		for _, ad := range ads {
+			totals[ad.ID] = ad.TotalClicks
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to save click batch: %v", err)
+		return nil, nil, err
 	}
-	return nil
+	return inserted, totals, nil
 }
 
 func (r *ClickRepo) GetAdTotalClicks(adID string) (int, error) {
@@ -50,18 +106,112 @@ func (r *ClickRepo) GetAdTotalClicks(adID string) (int, error) {
 }
 
 func (r *ClickRepo) GetClickCountByTimeFrame(adID string, timeFrame time.Duration) (int64, error) {
-	var count int64
-	timeAgo := time.Now().Add(-timeFrame)
+	now := time.Now()
+	counts, err := r.countClicks([]string{adID}, now.Add(-timeFrame), now)
+	if err != nil {
+		return 0, err
+	}
+	return counts[adID], nil
+}
 
-	err := r.DB.Model(&model.Click{}).
-		Where("ad_id = ? AND timestamp > ?", adID, timeAgo).
-		Count(&count).Error
+// GetClickCountsByAds is GetClickCountByTimeFrame for several ads at once, keyed by ad ID
+func (r *ClickRepo) GetClickCountsByAds(adIDs []string, timeFrame time.Duration) (map[string]int64, error) {
+	now := time.Now()
+	return r.countClicks(adIDs, now.Add(-timeFrame), now)
+}
 
+// GetClickTimeSeries aggregates the unflagged clicks of an ad into the buckets delimited by
+// boundaries (n+1 boundaries make n buckets). Buckets without clicks are left out.
+// When the buckets line up with a rollup, compacted buckets come from the rollup.
+func (r *ClickRepo) GetClickTimeSeries(adID string, boundaries []time.Time) (map[int]model.ClickBucket, error) {
+	buckets := make(map[int]model.ClickBucket)
+	if len(boundaries) < 2 {
+		return buckets, nil
+	}
+	rawFrom := 0
+	if g, ok := rollupForBuckets(boundaries); ok {
+		watermark, err := r.GetRollupWatermark(*g)
+		if err != nil {
+			return nil, err
+		}
+		// buckets ending at or before the watermark are complete in the rollup
+		for rawFrom < len(boundaries)-1 && !boundaries[rawFrom+1].After(watermark) {
+			rawFrom++
+		}
+		if rawFrom > 0 {
+			rollups, err := r.getRollupBuckets(adID, g, boundaries[0], boundaries[rawFrom])
+			if err != nil {
+				return nil, err
+			}
+			for _, rollup := range rollups {
+				index := int(rollup.BucketStart.Sub(boundaries[0]) / g.Size)
+				bucket := model.ClickBucket{
+					Start:     boundaries[index],
+					Clicks:    rollup.Clicks,
+					UniqueIPs: rollup.UniqueIPs,
+				}
+				if rollup.Clicks > 0 {
+					bucket.AvgPlaybackTime = float64(rollup.PlaybackSum) / float64(rollup.Clicks)
+				}
+				buckets[index] = bucket
+			}
+		}
+	}
+	if rawFrom == len(boundaries)-1 {
+		return buckets, nil
+	}
+
+	rawBoundaries := boundaries[rawFrom:]
+	bucketExpr, bucketArgs := bucketExpression(rawBoundaries)
+	var rows []struct {
+		Bucket      int     `gorm:"column:bucket"`
+		Clicks      int64   `gorm:"column:clicks"`
+		AvgPlayback float64 `gorm:"column:avg_playback"`
+		UniqueIPs   int64   `gorm:"column:unique_ips"`
+	}
+	err := r.DB.Model(&model.Click{}).
+		Select(bucketExpr+" AS bucket, COUNT(*) AS clicks, COALESCE(AVG(playback_time), 0) AS avg_playback, COUNT(DISTINCT ip) AS unique_ips", bucketArgs...).
+		Where("ad_id = ? AND flagged = ? AND timestamp >= ? AND timestamp < ?", adID, false, rawBoundaries[0], rawBoundaries[len(rawBoundaries)-1]).
+		Group("bucket").
+		Scan(&rows).Error
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	for _, row := range rows {
+		index := rawFrom + row.Bucket
+		buckets[index] = model.ClickBucket{
+			Start:           boundaries[index],
+			Clicks:          row.Clicks,
+			AvgPlaybackTime: row.AvgPlayback,
+			UniqueIPs:       row.UniqueIPs,
+		}
+	}
+	return buckets, nil
+}
 
-	return count, nil
+// bucketExpression maps a click timestamp to its bucket index. Evenly spaced buckets use integer
+// division; uneven ones (calendar days across a DST change) fall back to a CASE over the boundaries.
+func bucketExpression(boundaries []time.Time) (string, []interface{}) {
+	step := boundaries[1].Sub(boundaries[0])
+	even := true
+	for i := 2; i < len(boundaries); i++ {
+		if boundaries[i].Sub(boundaries[i-1]) != step {
+			even = false
+			break
+		}
+	}
+	if even {
+		return "TIMESTAMPDIFF(SECOND, ?, timestamp) DIV ?", []interface{}{boundaries[0], int64(step.Seconds())}
+	}
+	var expr strings.Builder
+	args := make([]interface{}, 0, len(boundaries)-2)
+	expr.WriteString("CASE")
+	for i := 1; i < len(boundaries)-1; i++ {
+		fmt.Fprintf(&expr, " WHEN timestamp < ? THEN %d", i-1)
+		args = append(args, boundaries[i])
+	}
+	fmt.Fprintf(&expr, " ELSE %d END", len(boundaries)-2)
+	return expr.String(), args
 }
 
 func (r *ClickRepo) AdExists(adID string) (bool, error) {
@@ -86,3 +236,34 @@ func (r *ClickRepo) GetClickCountByIP(ip string) (int, error) {
 	}
 	return int(count), nil
 }
+
+func (r *ClickRepo) GetClickCountByIPAndAd(ip string, adID string) (int, error) {
+	var count int64
+	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	err := r.DB.Model(&model.Click{}).
+		Where("ip = ? AND ad_id = ? AND timestamp > ?", ip, adID, oneHourAgo).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// GetFlaggedClicks returns the number of clicks flagged as fraudulent for an ad within the time frame
+// and the latest limit of them
+func (r *ClickRepo) GetFlaggedClicks(adID string, timeFrame time.Duration, limit int) (int64, []model.Click, error) {
+	timeAgo := time.Now().Add(-timeFrame)
+	query := r.DB.Model(&model.Click{}).
+		Where("ad_id = ? AND timestamp > ? AND flagged = ?", adID, timeAgo, true).
+		Session(&gorm.Session{}) // reused for the count and the rows
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 0, nil, err
+	}
+	var clicks []model.Click
+	if err := query.Order("timestamp DESC").Limit(limit).Find(&clicks).Error; err != nil {
+		return 0, nil, err
+	}
+	return count, clicks, nil
+}
@@ -0,0 +1,240 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RollupGranularity is one of the click rollup tables
+type RollupGranularity struct {
+	Name  string
+	Size  time.Duration
+	model interface{}
+}
+
+var (
+	RollupMinute = RollupGranularity{Name: "minute", Size: time.Minute, model: &model.ClickRollupMinute{}}
+	RollupHour   = RollupGranularity{Name: "hour", Size: time.Hour, model: &model.ClickRollupHour{}}
+	RollupDay    = RollupGranularity{Name: "day", Size: 24 * time.Hour, model: &model.ClickRollupDay{}}
+
+	// RollupGranularities lists the rollups from coarsest to finest
+	RollupGranularities = []RollupGranularity{RollupDay, RollupHour, RollupMinute}
+)
+
+// rangeSegment is a part of a queried time range served by one rollup, or by raw clicks when rollup is nil
+type rangeSegment struct {
+	rollup   *RollupGranularity
+	from, to time.Time
+}
+
+// GetRollupWatermark returns up to when a rollup is complete, zero if it was never compacted
+func (r *ClickRepo) GetRollupWatermark(g RollupGranularity) (time.Time, error) {
+	var watermark model.ClickRollupWatermark
+	err := r.DB.Where("granularity = ?", g.Name).First(&watermark).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return watermark.CompactedUntil, nil
+}
+
+func (r *ClickRepo) SetRollupWatermark(g RollupGranularity, until time.Time) error {
+	return r.DB.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&model.ClickRollupWatermark{Granularity: g.Name, CompactedUntil: until}).Error
+}
+
+// AdvanceRollupWatermark moves a rollup's watermark from from to until, ok is false when it was
+// moved in the meantime, by ReopenRollups, and was left as it is
+func (r *ClickRepo) AdvanceRollupWatermark(g RollupGranularity, from, until time.Time) (ok bool, err error) {
+	result := r.DB.Model(&model.ClickRollupWatermark{}).
+		Where("granularity = ? AND compacted_until = ?", g.Name, from).
+		Update("compacted_until", until)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReopenRollups moves the rollup watermarks back to the buckets of since when they are past it,
+// so clicks stored after their buckets were compacted, e.g. replayed dead letters, are compacted
+// again instead of missing from the rollups
+func (r *ClickRepo) ReopenRollups(since time.Time) error {
+	var watermarks []model.ClickRollupWatermark
+	if err := r.DB.Find(&watermarks).Error; err != nil {
+		return err
+	}
+	for _, watermark := range watermarks {
+		for _, g := range RollupGranularities {
+			bucket := since.Truncate(g.Size)
+			if g.Name != watermark.Granularity || !watermark.CompactedUntil.After(bucket) {
+				continue
+			}
+			err := r.DB.Model(&model.ClickRollupWatermark{}).
+				Where("granularity = ? AND compacted_until > ?", g.Name, bucket).
+				Update("compacted_until", bucket).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetFirstClickTime returns the timestamp of the oldest click, ok is false when there are none
+func (r *ClickRepo) GetFirstClickTime() (first time.Time, ok bool, err error) {
+	var clicks []model.Click
+	if err := r.DB.Select("timestamp").Order("timestamp ASC").Limit(1).Find(&clicks).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	if len(clicks) == 0 {
+		return time.Time{}, false, nil
+	}
+	return clicks[0].Timestamp, true, nil
+}
+
+// CompactRollup recomputes the rollup buckets in [from, to) from raw clicks. Buckets are replaced
+// rather than incremented, so compacting the same range again is safe.
+func (r *ClickRepo) CompactRollup(g RollupGranularity, from, to time.Time) error {
+	var rows []struct {
+		AdID        string `gorm:"column:ad_id"`
+		Bucket      int64  `gorm:"column:bucket"`
+		Clicks      int64  `gorm:"column:clicks"`
+		PlaybackSum int64  `gorm:"column:playback_sum"`
+		UniqueIPs   int64  `gorm:"column:unique_ips"`
+	}
+	err := r.DB.Model(&model.Click{}).
+		Select("ad_id, TIMESTAMPDIFF(SECOND, ?, timestamp) DIV ? AS bucket, COUNT(*) AS clicks, COALESCE(SUM(playback_time), 0) AS playback_sum, COUNT(DISTINCT ip) AS unique_ips", from, int64(g.Size.Seconds())).
+		Where("flagged = ? AND timestamp >= ? AND timestamp < ?", false, from, to).
+		Group("ad_id, bucket").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	rollups := make([]model.ClickRollup, len(rows))
+	for i, row := range rows {
+		rollups[i] = model.ClickRollup{
+			AdID:        row.AdID,
+			BucketStart: from.Add(time.Duration(row.Bucket) * g.Size),
+			Clicks:      row.Clicks,
+			PlaybackSum: row.PlaybackSum,
+			UniqueIPs:   row.UniqueIPs,
+		}
+	}
+	return r.DB.Table(r.rollupTable(g)).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		CreateInBatches(&rollups, 500).Error
+}
+
+func (r *ClickRepo) rollupTable(g RollupGranularity) string {
+	stmt := &gorm.Statement{DB: r.DB}
+	if err := stmt.Parse(g.model); err != nil {
+		// the rollup models are static, failing to parse them is a programming error
+		panic(err)
+	}
+	return stmt.Table
+}
+
+// countClicks counts unflagged clicks per ad in [from, to), reading whole buckets from the
+// coarsest compacted rollup and only the edges from raw clicks
+func (r *ClickRepo) countClicks(adIDs []string, from, to time.Time) (map[string]int64, error) {
+	counts := make(map[string]int64, len(adIDs))
+	if len(adIDs) == 0 {
+		return counts, nil
+	}
+	watermarks := make(map[string]time.Time, len(RollupGranularities))
+	for _, g := range RollupGranularities {
+		watermark, err := r.GetRollupWatermark(g)
+		if err != nil {
+			return nil, err
+		}
+		watermarks[g.Name] = watermark
+	}
+
+	for _, segment := range planRange(from, to, RollupGranularities, watermarks) {
+		var rows []struct {
+			AdID  string `gorm:"column:ad_id"`
+			Count int64  `gorm:"column:count"`
+		}
+		var query *gorm.DB
+		if segment.rollup == nil {
+			query = r.DB.Model(&model.Click{}).
+				Select("ad_id, COUNT(*) AS count").
+				Where("ad_id IN ? AND flagged = ? AND timestamp >= ? AND timestamp < ?", adIDs, false, segment.from, segment.to)
+		} else {
+			query = r.DB.Model(segment.rollup.model).
+				Select("ad_id, SUM(clicks) AS count").
+				Where("ad_id IN ? AND bucket_start >= ? AND bucket_start < ?", adIDs, segment.from, segment.to)
+		}
+		if err := query.Group("ad_id").Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			counts[row.AdID] += row.Count
+		}
+	}
+	return counts, nil
+}
+
+// planRange splits [from, to) into segments: the largest aligned middle part goes to the first
+// (coarsest) rollup that is compacted that far, the leftovers on both sides recurse into finer
+// rollups and end up as raw segments
+func planRange(from, to time.Time, granularities []RollupGranularity, watermarks map[string]time.Time) []rangeSegment {
+	if !from.Before(to) {
+		return nil
+	}
+	if len(granularities) == 0 {
+		return []rangeSegment{{from: from, to: to}}
+	}
+	g := granularities[0]
+	limit := to
+	if watermark := watermarks[g.Name]; watermark.Before(limit) {
+		limit = watermark
+	}
+	start := from.Truncate(g.Size)
+	if start.Before(from) {
+		start = start.Add(g.Size)
+	}
+	end := limit.Truncate(g.Size)
+	if !start.Before(end) {
+		return planRange(from, to, granularities[1:], watermarks)
+	}
+	segments := planRange(from, start, granularities[1:], watermarks)
+	segments = append(segments, rangeSegment{rollup: &g, from: start, to: end})
+	return append(segments, planRange(end, to, granularities[1:], watermarks)...)
+}
+
+// rollupForBuckets returns the rollup whose buckets are exactly the evenly spaced buckets
+// delimited by boundaries, if there is one
+func rollupForBuckets(boundaries []time.Time) (*RollupGranularity, bool) {
+	step := boundaries[1].Sub(boundaries[0])
+	for i := 2; i < len(boundaries); i++ {
+		if boundaries[i].Sub(boundaries[i-1]) != step {
+			return nil, false
+		}
+	}
+	for _, g := range RollupGranularities {
+		if g.Size == step && boundaries[0].Equal(boundaries[0].Truncate(g.Size)) {
+			g := g
+			return &g, true
+		}
+	}
+	return nil, false
+}
+
+// getRollupBuckets reads compacted rollup rows of an ad for the buckets starting in [from, to)
+func (r *ClickRepo) getRollupBuckets(adID string, g *RollupGranularity, from, to time.Time) ([]model.ClickRollup, error) {
+	var rollups []model.ClickRollup
+	err := r.DB.Model(g.model).
+		Where("ad_id = ? AND bucket_start >= ? AND bucket_start < ?", adID, from, to).
+		Scan(&rollups).Error
+	if err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
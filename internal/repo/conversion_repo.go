@@ -0,0 +1,39 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"gorm.io/gorm"
+)
+
+type ConversionRepo struct {
+	DB *gorm.DB
+}
+
+func NewConversionRepo(db *gorm.DB) *ConversionRepo {
+	return &ConversionRepo{DB: db}
+}
+
+func (r *ConversionRepo) Save(conversion *model.Conversion) error {
+	return r.DB.Create(conversion).Error
+}
+
+// GetAttributedCountByTimeFrame counts conversions of an ad within the time frame whose click
+// happened no more than window before the conversion
+func (r *ConversionRepo) GetAttributedCountByTimeFrame(adID string, timeFrame time.Duration, window time.Duration) (int64, error) {
+	var count int64
+	timeAgo := time.Now().Add(-timeFrame)
+
+	err := r.DB.Model(&model.Conversion{}).
+		Joins("Click").
+		Where("Click.ad_id = ? AND Click.flagged = ? AND converted_at > ?", adID, false, timeAgo).
+		Where("converted_at >= Click.timestamp AND converted_at <= DATE_ADD(Click.timestamp, INTERVAL ? SECOND)", int64(window.Seconds())).
+		Count(&count).Error
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,42 @@
+package repo
+
+import (
+	"log"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ImpressionRepo struct {
+	DB *gorm.DB
+}
+
+func NewImpressionRepo(db *gorm.DB) *ImpressionRepo {
+	return &ImpressionRepo{DB: db}
+}
+
+// SaveBatch stores impressions, skipping the ones already stored so a redelivered batch is harmless
+func (r *ImpressionRepo) SaveBatch(impressions []model.Impression) error {
+	if err := r.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&impressions, 500).Error; err != nil {
+		log.Printf("Failed to save impression events: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *ImpressionRepo) GetImpressionCountByTimeFrame(adID string, timeFrame time.Duration) (int64, error) {
+	var count int64
+	timeAgo := time.Now().Add(-timeFrame)
+
+	err := r.DB.Model(&model.Impression{}).
+		Where("ad_id = ? AND timestamp > ?", adID, timeAgo).
+		Count(&count).Error
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ArjunMalhotra/internal/services"
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultDeadLetters = 50
+	maxDeadLetters     = 500
+)
+
+// requireAdmin guards the /admin endpoints with the ADMIN_TOKEN bearer token, they are
+// disabled altogether while no token is configured
+func (s *HttpServer) requireAdmin(c *fiber.Ctx) error {
+	if s.Cfg.Admin.Token == "" {
+		return s.App.HttpResponseForbidden(c, errors.New("admin endpoints are disabled, ADMIN_TOKEN is not set"))
+	}
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || token == "" {
+		return s.App.HttpResponseUnauthorized(c, errors.New("admin endpoints need an Authorization: Bearer token"))
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.Cfg.Admin.Token)) != 1 {
+		return s.App.HttpResponseForbidden(c, fmt.Errorf("invalid admin token from %s", c.IP()))
+	}
+	return c.Next()
+}
+
+func (s *HttpServer) handleGetDeadLetters(c *fiber.Ctx) error {
+	limit, err := queryInt(c, "limit")
+	if err != nil {
+		return s.App.HttpResponseBadQueryParams(c, err)
+	}
+	if limit == 0 || limit > maxDeadLetters {
+		limit = defaultDeadLetters
+	}
+
+	letters, err := s.ClickService.GetDeadLetters(limit)
+	if err != nil {
+		return s.App.HttpResponseInternalServerErrorRequest(c, err)
+	}
+	return s.App.HttpResponseOK(c, letters)
+}
+
+func (s *HttpServer) handleReplayDeadLetter(c *fiber.Ctx) error {
+	partition, err := strconv.ParseInt(c.Params("partition"), 10, 32)
+	if err != nil || partition < 0 {
+		return s.App.HttpResponseBadRequest(c, fmt.Errorf("partition must be a non-negative integer, got %q", c.Params("partition")))
+	}
+	offset, err := strconv.ParseInt(c.Params("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return s.App.HttpResponseBadRequest(c, fmt.Errorf("offset must be a non-negative integer, got %q", c.Params("offset")))
+	}
+
+	if err := s.ClickService.ReplayDeadLetter(int32(partition), offset); err != nil {
+		if errors.Is(err, services.ErrDeadLetterNotFound) {
+			return s.App.HttpResponseNotFound(c, fmt.Errorf("no dead letter at partition %d offset %d", partition, offset))
+		}
+		return s.App.HttpResponseInternalServerErrorRequest(c, err)
+	}
+	return s.App.HttpResponseOK(c, fiber.Map{
+		"partition": partition,
+		"offset":    offset,
+		"replayed":  true,
+	})
+}
+
+// handleGetConsumers lists the consumer groups and whether their workers are running,
+// rebalancing or stopped
+func (s *HttpServer) handleGetConsumers(c *fiber.Ctx) error {
+	return s.App.HttpResponseOK(c, s.Bus.Subscriptions())
+}
+
+// handleGetCircuitBreakers lists the stats of every circuit breaker
+func (s *HttpServer) handleGetCircuitBreakers(c *fiber.Ctx) error {
+	breakers := s.Breakers.All()
+	stats := make([]circuitbreaker.Stats, len(breakers))
+	for i, cb := range breakers {
+		stats[i] = cb.Stats()
+	}
+	return s.App.HttpResponseOK(c, stats)
+}
+
+func (s *HttpServer) handleGetCircuitBreaker(c *fiber.Ctx) error {
+	cb, ok := s.Breakers.Lookup(c.Params("name"))
+	if !ok {
+		return s.App.HttpResponseNotFound(c, fmt.Errorf("no circuit breaker named %q", c.Params("name")))
+	}
+	return s.App.HttpResponseOK(c, cb.Stats())
+}
+
+// handleControlCircuitBreaker forces a circuit breaker open or closed, or resets it, for
+// taking a dependency out of or back into service during an incident
+func (s *HttpServer) handleControlCircuitBreaker(action string, control func(*circuitbreaker.CircuitBreaker)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cb, ok := s.Breakers.Lookup(c.Params("name"))
+		if !ok {
+			return s.App.HttpResponseNotFound(c, fmt.Errorf("no circuit breaker named %q", c.Params("name")))
+		}
+		control(cb)
+		s.Log.Logger.Warnf("Circuit breaker %s: %s by %s", cb.Name(), action, c.IP())
+		return s.App.HttpResponseOK(c, cb.Stats())
+	}
+}
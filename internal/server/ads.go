@@ -1,13 +1,178 @@
 package server
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRecentClicks = 10
+)
 
 func (s *HttpServer) GetAds(c *fiber.Ctx) error {
-	ads, err := s.AdService.GetAllAds()
+	q, err := parseAdListQuery(c)
+	if err != nil {
+		return s.App.HttpResponseBadQueryParams(c, err)
+	}
+	page, err := s.AdService.ListAds(q)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch ads",
-		})
+		if errors.Is(err, services.ErrInvalidAdQuery) {
+			return s.App.HttpResponseBadQueryParams(c, err)
+		}
+		return s.App.HttpResponseInternalServerErrorRequest(c, fmt.Errorf("failed to fetch ads: %w", err))
+	}
+	return s.App.HttpResponseOK(c, page)
+}
+
+// parseAdListQuery reads the listing options from the query string:
+// cursor, page_size, sort, order, campaign_id, created_from, created_to, has_clicks,
+// include_deleted and include=recent_clicks with limit
+func parseAdListQuery(c *fiber.Ctx) (model.AdListQuery, error) {
+	q := model.AdListQuery{
+		SortBy:     c.Query("sort", "created_at"),
+		Cursor:     c.Query("cursor"),
+		CampaignID: c.Query("campaign_id"),
+	}
+	switch order := c.Query("order", "desc"); order {
+	case "desc":
+		q.Desc = true
+	case "asc":
+	default:
+		return q, fmt.Errorf("order must be asc or desc, got %q", order)
+	}
+	var err error
+	if q.PageSize, err = queryInt(c, "page_size"); err != nil {
+		return q, err
+	}
+	if q.CreatedFrom, err = queryTime(c, "created_from"); err != nil {
+		return q, err
+	}
+	if q.CreatedTo, err = queryTime(c, "created_to"); err != nil {
+		return q, err
+	}
+	if raw := c.Query("has_clicks"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, fmt.Errorf("has_clicks must be a boolean, got %q", raw)
+		}
+		q.HasClicks = &v
+	}
+	if raw := c.Query("include_deleted"); raw != "" {
+		if q.IncludeDeleted, err = strconv.ParseBool(raw); err != nil {
+			return q, fmt.Errorf("include_deleted must be a boolean, got %q", raw)
+		}
+	}
+	for _, include := range strings.Split(c.Query("include"), ",") {
+		switch include {
+		case "":
+		case "recent_clicks":
+			if q.RecentClicks, err = queryInt(c, "limit"); err != nil {
+				return q, err
+			}
+			if q.RecentClicks == 0 {
+				q.RecentClicks = defaultRecentClicks
+			}
+		default:
+			return q, fmt.Errorf("unknown include %q", include)
+		}
+	}
+	return q, nil
+}
+
+func queryInt(c *fiber.Ctx, key string) (int, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", key, raw)
+	}
+	return v, nil
+}
+
+func queryTime(c *fiber.Ctx, key string) (*time.Time, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC3339 timestamp, got %q", key, raw)
+	}
+	return &t, nil
+}
+
+func (s *HttpServer) handleCreateAd(c *fiber.Ctx) error {
+	var input model.AdInput
+	if err := c.BodyParser(&input); err != nil {
+		return s.App.HttpResponseBadRequest(c, fmt.Errorf("invalid request data: %w", err))
+	}
+	ad, err := s.AdService.CreateAd(input)
+	if err != nil {
+		return s.adError(c, err)
+	}
+	return s.App.HttpResponseCreated(c, ad)
+}
+
+func (s *HttpServer) handleGetAd(c *fiber.Ctx) error {
+	ad, err := s.AdService.GetAd(c.Params("id"))
+	if err != nil {
+		return s.adError(c, err)
+	}
+	return s.App.HttpResponseOK(c, ad)
+}
+
+func (s *HttpServer) handleUpdateAd(c *fiber.Ctx) error {
+	var input model.AdInput
+	if err := c.BodyParser(&input); err != nil {
+		return s.App.HttpResponseBadRequest(c, fmt.Errorf("invalid request data: %w", err))
+	}
+	ad, err := s.AdService.UpdateAd(c.Params("id"), input)
+	if err != nil {
+		return s.adError(c, err)
+	}
+	return s.App.HttpResponseOK(c, ad)
+}
+
+func (s *HttpServer) handleDeleteAd(c *fiber.Ctx) error {
+	if err := s.AdService.DeleteAd(c.Params("id")); err != nil {
+		return s.adError(c, err)
+	}
+	return s.App.HttpResponseNoContent(c)
+}
+
+func (s *HttpServer) handleRestoreAd(c *fiber.Ctx) error {
+	ad, err := s.AdService.RestoreAd(c.Params("id"))
+	if err != nil {
+		return s.adError(c, err)
+	}
+	return s.App.HttpResponseOK(c, ad)
+}
+
+// adError maps ad service errors onto the http response envelope
+func (s *HttpServer) adError(c *fiber.Ctx, err error) error {
+	return s.serviceError(c, "ad", err)
+}
+
+// serviceError maps validation and lookup errors from the services onto the http response envelope
+func (s *HttpServer) serviceError(c *fiber.Ctx, kind string, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidAd),
+		errors.Is(err, services.ErrInvalidCampaign),
+		errors.Is(err, services.ErrInvalidAdvertiser):
+		return s.App.HttpResponseBadRequest(c, err)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return s.App.HttpResponseNotFound(c, fmt.Errorf("%s %s not found", kind, c.Params("id")))
+	default:
+		return s.App.HttpResponseInternalServerErrorRequest(c, err)
 	}
-	return c.JSON(ads)
 }
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s *HttpServer) handleListAdvertisers(c *fiber.Ctx) error {
+	advertisers, err := s.AdvertiserService.ListAdvertisers()
+	if err != nil {
+		return s.serviceError(c, "advertiser", err)
+	}
+	return s.App.HttpResponseOK(c, advertisers)
+}
+
+func (s *HttpServer) handleCreateAdvertiser(c *fiber.Ctx) error {
+	var input model.AdvertiserInput
+	if err := c.BodyParser(&input); err != nil {
+		return s.App.HttpResponseBadRequest(c, fmt.Errorf("invalid request data: %w", err))
+	}
+	advertiser, err := s.AdvertiserService.CreateAdvertiser(input)
+	if err != nil {
+		return s.serviceError(c, "advertiser", err)
+	}
+	return s.App.HttpResponseCreated(c, advertiser)
+}
+
+func (s *HttpServer) handleGetAdvertiser(c *fiber.Ctx) error {
+	advertiser, err := s.AdvertiserService.GetAdvertiser(c.Params("id"))
+	if err != nil {
+		return s.serviceError(c, "advertiser", err)
+	}
+	return s.App.HttpResponseOK(c, advertiser)
+}
+
+func (s *HttpServer) handleUpdateAdvertiser(c *fiber.Ctx) error {
+	var input model.AdvertiserInput
+	if err := c.BodyParser(&input); err != nil {
+		return s.App.HttpResponseBadRequest(c, fmt.Errorf("invalid request data: %w", err))
+	}
+	advertiser, err := s.AdvertiserService.UpdateAdvertiser(c.Params("id"), input)
+	if err != nil {
+		return s.serviceError(c, "advertiser", err)
+	}
+	return s.App.HttpResponseOK(c, advertiser)
+}
+
+func (s *HttpServer) handleDeleteAdvertiser(c *fiber.Ctx) error {
+	if err := s.AdvertiserService.DeleteAdvertiser(c.Params("id")); err != nil {
+		return s.serviceError(c, "advertiser", err)
+	}
+	return s.App.HttpResponseNoContent(c)
+}
+
+func (s *HttpServer) handleGetAdvertiserAnalytics(c *fiber.Ctx) error {
+	advertiserID := c.Params("id")
+	timeFrame := c.Query("timeframe", "1h")
+	total, byCampaign, err := s.AdvertiserService.GetAdvertiserAnalytics(advertiserID, timeFrame)
+	if err != nil {
+		return s.serviceError(c, "advertiser", err)
+	}
+	return s.App.HttpResponseOK(c, fiber.Map{
+		"advertiser_id": advertiserID,
+		"timeframe":     timeFrame,
+		"clicks":        total.Clicks,
+		"total_clicks":  total.TotalClicks,
+		"campaigns":     clickBreakdown("campaign_id", byCampaign),
+	})
+}
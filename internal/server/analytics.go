@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	_ "time/tzdata" // the runtime image ships without a zoneinfo database
+
+	"github.com/ArjunMalhotra/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTimeSeriesRange = 24 * time.Hour
+)
+
+func (s *HttpServer) handleGetClickTimeSeries(c *fiber.Ctx) error {
+	adID := c.Params("id")
+	if adID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Ad ID is required",
+		})
+	}
+
+	tz := c.Query("tz", "UTC")
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Unknown timezone %q", tz),
+		})
+	}
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "to must be an RFC3339 timestamp",
+			})
+		}
+	}
+	from := to.Add(-defaultTimeSeriesRange)
+	if raw := c.Query("from"); raw != "" {
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "from must be an RFC3339 timestamp",
+			})
+		}
+	}
+	interval := c.Query("interval", "1h")
+
+	series, err := s.ClickService.GetClickTimeSeries(adID, from, to, interval, loc)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Ad not found",
+			})
+		}
+		if errors.Is(err, services.ErrInvalidTimeSeries) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		s.Log.Logger.Errorf("Failed to get click time series: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"ad_id":    adID,
+		"from":     from.In(loc),
+		"to":       to.In(loc),
+		"interval": interval,
+		"timezone": loc.String(),
+		"buckets":  series,
+	})
+}
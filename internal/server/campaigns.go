@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s *HttpServer) handleListCampaigns(c *fiber.Ctx) error {
+	campaigns, err := s.CampaignService.ListCampaigns(c.Query("advertiser_id"), c.Query("status"))
+	if err != nil {
+		return s.serviceError(c, "campaign", err)
+	}
+	return s.App.HttpResponseOK(c, campaigns)
+}
+
+func (s *HttpServer) handleCreateCampaign(c *fiber.Ctx) error {
+	var input model.CampaignInput
+	if err := c.BodyParser(&input); err != nil {
+		return s.App.HttpResponseBadRequest(c, fmt.Errorf("invalid request data: %w", err))
+	}
+	campaign, err := s.CampaignService.CreateCampaign(input)
+	if err != nil {
+		return s.serviceError(c, "campaign", err)
+	}
+	return s.App.HttpResponseCreated(c, campaign)
+}
+
+func (s *HttpServer) handleGetCampaign(c *fiber.Ctx) error {
+	campaign, err := s.CampaignService.GetCampaign(c.Params("id"))
+	if err != nil {
+		return s.serviceError(c, "campaign", err)
+	}
+	return s.App.HttpResponseOK(c, campaign)
+}
+
+func (s *HttpServer) handleUpdateCampaign(c *fiber.Ctx) error {
+	var input model.CampaignInput
+	if err := c.BodyParser(&input); err != nil {
+		return s.App.HttpResponseBadRequest(c, fmt.Errorf("invalid request data: %w", err))
+	}
+	campaign, err := s.CampaignService.UpdateCampaign(c.Params("id"), input)
+	if err != nil {
+		return s.serviceError(c, "campaign", err)
+	}
+	return s.App.HttpResponseOK(c, campaign)
+}
+
+func (s *HttpServer) handleDeleteCampaign(c *fiber.Ctx) error {
+	if err := s.CampaignService.DeleteCampaign(c.Params("id")); err != nil {
+		return s.serviceError(c, "campaign", err)
+	}
+	return s.App.HttpResponseNoContent(c)
+}
+
+func (s *HttpServer) handleGetCampaignAnalytics(c *fiber.Ctx) error {
+	campaignID := c.Params("id")
+	timeFrame := c.Query("timeframe", "1h")
+	total, byAd, err := s.CampaignService.GetCampaignAnalytics(campaignID, timeFrame)
+	if err != nil {
+		return s.serviceError(c, "campaign", err)
+	}
+	return s.App.HttpResponseOK(c, fiber.Map{
+		"campaign_id":  campaignID,
+		"timeframe":    timeFrame,
+		"clicks":       total.Clicks,
+		"total_clicks": total.TotalClicks,
+		"ads":          clickBreakdown("ad_id", byAd),
+	})
+}
+
+// clickBreakdown flattens per-child click stats into a list sorted by ID
+func clickBreakdown(idKey string, stats map[string]model.ClickStats) []fiber.Map {
+	ids := make([]string, 0, len(stats))
+	for id := range stats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	breakdown := make([]fiber.Map, 0, len(ids))
+	for _, id := range ids {
+		breakdown = append(breakdown, fiber.Map{
+			idKey:          id,
+			"clicks":       stats[id].Clicks,
+			"total_clicks": stats[id].TotalClicks,
+		})
+	}
+	return breakdown
+}
@@ -1,22 +1,40 @@
 package server
 
 import (
+	"errors"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/ArjunMalhotra/internal/events"
 	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/services"
+	"github.com/ArjunMalhotra/pkg/clicktoken"
+	"github.com/ArjunMalhotra/pkg/counter"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+const (
+	maxUserAgentLength   = 512
+	maxReferrerLength    = 2048
+	defaultFlaggedClicks = 50
+	maxFlaggedClicks     = 500
+)
+
 func (s *HttpServer) handleRecordClick(c *fiber.Ctx) error {
 	//! Parse
-	var click model.Click
-	if err := c.BodyParser(&click); err != nil {
+	var req struct {
+		model.Click
+		ClickToken string `json:"click_token"`
+	}
+	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request data",
 		})
 	}
+	click := req.Click
 	//! Quick validation
 	if click.AdID == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -28,20 +46,117 @@ func (s *HttpServer) handleRecordClick(c *fiber.Ctx) error {
 			"error": "Ad Playback time must be greater than zero",
 		})
 	}
+	//! Signature check
+	click.ImpressionID = ""
+	if s.ClickSigner != nil {
+		claims, status, err := s.verifyClickToken(req.ClickToken, click.AdID)
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		click.ImpressionID = claims.ImpressionID
+	}
 	click.ID = uuid.New().String()
 	click.IP = c.IP()
+	click.UserAgent = truncate(c.Get(fiber.HeaderUserAgent), maxUserAgentLength)
+	click.Referrer = truncate(c.Get(fiber.HeaderReferer), maxReferrerLength)
 	click.Timestamp = time.Now()
 	//! Async processing - don't wait for this to complete
-	go func() {
-		if err := s.ClickService.RecordClick(click); err != nil {
-			s.Log.Logger.Errorf("Failed to record click", "error", err)
+	if err := s.ClickService.RecordClick(click, events.SourceAPI); err != nil {
+		if errors.Is(err, services.ErrQueueFull) {
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "Too many clicks in flight, try again later",
+			})
 		}
-	}()
+		s.Log.Logger.Errorf("Failed to record click: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record click",
+		})
+	}
 	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
-		"message": "Click recorded",
+		"message":  "Click recorded",
+		"click_id": click.ID,
 	})
 }
 
+// verifyClickToken checks a click token was signed for adID, the status is the one to answer
+// with when it wasn't
+func (s *HttpServer) verifyClickToken(token, adID string) (*clicktoken.Claims, int, error) {
+	claims, err := s.ClickSigner.Verify(token)
+	if err != nil {
+		if errors.Is(err, clicktoken.ErrMissing) {
+			return nil, fiber.StatusUnauthorized, err
+		}
+		return nil, fiber.StatusForbidden, err
+	}
+	if claims.AdID != adID {
+		return nil, fiber.StatusForbidden, errors.New("click token was issued for another ad")
+	}
+	return claims, fiber.StatusOK, nil
+}
+
+// handleClickRedirect lets an ad tag be a plain link: it records the click and sends the
+// browser on to the ad's target url. With click signing on, the link must carry the token of
+// the impression in ?t=.
+func (s *HttpServer) handleClickRedirect(c *fiber.Ctx) error {
+	adID := c.Params("id")
+	var impressionID string
+	if s.ClickSigner != nil {
+		claims, status, err := s.verifyClickToken(c.Query("t"), adID)
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		impressionID = claims.ImpressionID
+	}
+	ad, target, err := s.AdService.ResolveRedirect(adID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Ad not found",
+			})
+		}
+		s.Log.Logger.Errorf("Failed to resolve redirect for ad %s: %v", adID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	click := model.Click{
+		ID:           uuid.New().String(),
+		AdID:         ad.ID,
+		ImpressionID: impressionID,
+		IP:           c.IP(),
+		PlaybackTime: max(c.QueryInt("playback_time", 0), 0),
+		UserAgent:    truncate(c.Get(fiber.HeaderUserAgent), maxUserAgentLength),
+		Referrer:     truncate(c.Get(fiber.HeaderReferer), maxReferrerLength),
+		Timestamp:    time.Now(),
+	}
+	//! Async processing - the redirect must not wait on Kafka
+	// the visitor is redirected even if the click is lost
+	if err := s.ClickService.RecordClick(click, events.SourceRedirect); err != nil {
+		s.Log.Logger.Errorf("Failed to record click: %v", err)
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Redirect(target, fiber.StatusFound)
+}
+
+// truncate cuts value to at most max bytes of valid UTF-8, without splitting a rune. Invalid
+// bytes in raw headers are replaced, they would be rejected by the utf8mb4 columns.
+func truncate(value string, max int) string {
+	value = strings.ToValidUTF8(value, "\uFFFD")
+	if len(value) <= max {
+		return value
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return value[:cut]
+}
+
 func (s *HttpServer) handleGetClickCount(c *fiber.Ctx) error {
 	adID := c.Params("id")
 	if adID == "" {
@@ -64,10 +179,36 @@ func (s *HttpServer) handleGetClickCount(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(fiber.Map{
+	response := fiber.Map{
 		"ad_id":        adID,
 		"total_clicks": count,
-	})
+	}
+
+	// Optionally add the clicks of the last few minutes from the counter's minute buckets
+	if window := c.Query("window"); window != "" {
+		duration, err := s.ClickService.ParseTimeFrame(window)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		recent, err := s.ClickService.GetRecentClickCount(adID, duration)
+		if err != nil {
+			if errors.Is(err, counter.ErrWindowTooLong) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "window is longer than recent clicks are kept",
+				})
+			}
+			s.Log.Logger.Errorf("Failed to get recent click count: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+		response["window"] = window
+		response["recent_clicks"] = recent
+	}
+
+	return c.JSON(response)
 }
 
 func (s *HttpServer) handleGetClickAnalytics(c *fiber.Ctx) error {
@@ -95,9 +236,72 @@ func (s *HttpServer) handleGetClickAnalytics(c *fiber.Ctx) error {
 		})
 	}
 
+	impressions, err := s.ImpressionService.GetImpressionCountByTimeFrame(adID, timeFrame)
+	if err != nil {
+		s.Log.Logger.Errorf("Failed to get impression analytics: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	conversions, err := s.ConversionService.GetConversionCountByTimeFrame(adID, timeFrame)
+	if err != nil {
+		s.Log.Logger.Errorf("Failed to get conversion analytics: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	// rates are undefined without a denominator, report them as zero
+	ctr, conversionRate := 0.0, 0.0
+	if impressions > 0 {
+		ctr = float64(count) / float64(impressions)
+	}
+	if count > 0 {
+		conversionRate = float64(conversions) / float64(count)
+	}
+
+	return c.JSON(fiber.Map{
+		"ad_id":              adID,
+		"timeframe":          timeFrame,
+		"clicks":             count,
+		"impressions":        impressions,
+		"ctr":                ctr,
+		"conversions":        conversions,
+		"conversion_rate":    conversionRate,
+		"attribution_window": s.ConversionService.AttributionWindow().String(),
+	})
+}
+
+func (s *HttpServer) handleGetFlaggedClicks(c *fiber.Ctx) error {
+	adID := c.Params("id")
+	if adID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Ad ID is required",
+		})
+	}
+
+	timeFrame := c.Query("timeframe", "24h")
+	limit := c.QueryInt("limit", defaultFlaggedClicks)
+	if limit <= 0 || limit > maxFlaggedClicks {
+		limit = defaultFlaggedClicks
+	}
+
+	count, clicks, err := s.ClickService.GetFlaggedClicks(adID, timeFrame, limit)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Ad not found",
+			})
+		}
+		s.Log.Logger.Errorf("Failed to get flagged clicks: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
 	return c.JSON(fiber.Map{
-		"ad_id":     adID,
-		"timeframe": timeFrame,
-		"clicks":    count,
+		"ad_id":          adID,
+		"timeframe":      timeFrame,
+		"flagged_clicks": count,
+		"clicks":         clicks,
 	})
 }
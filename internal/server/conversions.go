@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s *HttpServer) handleRecordConversion(c *fiber.Ctx) error {
+	//! Parse
+	var conversion model.Conversion
+	if err := c.BodyParser(&conversion); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request data",
+		})
+	}
+	saved, err := s.ConversionService.RecordConversion(conversion)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidConversion) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		s.Log.Logger.Errorf("Failed to record conversion: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":       "Conversion recorded",
+		"conversion_id": saved.ID,
+	})
+}
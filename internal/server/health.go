@@ -0,0 +1,21 @@
+package server
+
+import (
+	"github.com/ArjunMalhotra/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleHealthz answers as long as the process can serve requests
+func (s *HttpServer) handleHealthz(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": services.HealthUp})
+}
+
+// handleReadyz reports every dependency and fails with 503 while a critical one is down
+func (s *HttpServer) handleReadyz(c *fiber.Ctx) error {
+	report := s.HealthService.Check(c.UserContext())
+	status := fiber.StatusOK
+	if !report.Ready() {
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(report)
+}
@@ -3,25 +3,43 @@ package server
 import (
 	"github.com/ArjunMalhotra/config"
 	"github.com/ArjunMalhotra/internal/services"
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/ArjunMalhotra/pkg/clicktoken"
 	"github.com/ArjunMalhotra/pkg/http"
 	"github.com/ArjunMalhotra/pkg/logger"
 )
 
 type HttpServer struct {
-	Cfg          *config.Config
-	App          *http.App
-	Log          *logger.Logger
-	AdService    *services.AdService
-	ClickService *services.ClickService
+	Cfg               *config.Config
+	App               *http.App
+	Log               *logger.Logger
+	AdService         *services.AdService
+	ClickService      *services.ClickService
+	CampaignService   *services.CampaignService
+	AdvertiserService *services.AdvertiserService
+	ImpressionService *services.ImpressionService
+	ConversionService *services.ConversionService
+	HealthService     *services.HealthService
+	ClickSigner       *clicktoken.Signer // nil when click signing is disabled
+	Bus               services.EventBus
+	Breakers          *circuitbreaker.Registry
 }
 
-func NewHTTP(cfg *config.Config, app *http.App, log *logger.Logger, adService *services.AdService, clickService *services.ClickService) *HttpServer {
+func NewHTTP(cfg *config.Config, app *http.App, log *logger.Logger, adService *services.AdService, clickService *services.ClickService, campaignService *services.CampaignService, advertiserService *services.AdvertiserService, impressionService *services.ImpressionService, conversionService *services.ConversionService, healthService *services.HealthService, clickSigner *clicktoken.Signer, bus services.EventBus, breakers *circuitbreaker.Registry) *HttpServer {
 	server := &HttpServer{
-		Cfg:          cfg,
-		App:          app,
-		Log:          log,
-		AdService:    adService,
-		ClickService: clickService,
+		Cfg:               cfg,
+		App:               app,
+		Log:               log,
+		AdService:         adService,
+		ClickService:      clickService,
+		CampaignService:   campaignService,
+		AdvertiserService: advertiserService,
+		ImpressionService: impressionService,
+		ConversionService: conversionService,
+		HealthService:     healthService,
+		ClickSigner:       clickSigner,
+		Bus:               bus,
+		Breakers:          breakers,
 	}
 	server.RegisterRoutes()
 	return server
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (s *HttpServer) handleRecordImpression(c *fiber.Ctx) error {
+	//! Parse
+	var impression model.Impression
+	if err := c.BodyParser(&impression); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request data",
+		})
+	}
+	//! Quick validation
+	if impression.AdID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Ad ID is required",
+		})
+	}
+	//! Only live ads get impressions, and with them click tokens
+	if _, err := s.AdService.GetAd(impression.AdID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Ad not found",
+			})
+		}
+		s.Log.Logger.Errorf("Failed to look up ad %s: %v", impression.AdID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	impression.ID = uuid.New().String()
+	impression.IP = c.IP()
+	impression.Timestamp = time.Now()
+	response := fiber.Map{
+		"message":       "Impression recorded",
+		"impression_id": impression.ID,
+	}
+	//! Click token - the only way to get a click on this impression accepted
+	if s.ClickSigner != nil {
+		token, expiresAt, err := s.ClickSigner.Issue(impression.AdID, impression.ID)
+		if err != nil {
+			s.Log.Logger.Errorf("Failed to issue click token: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+		response["click_token"] = token
+		response["click_token_expires_at"] = expiresAt
+	}
+	//! Async processing - don't wait for this to complete
+	if err := s.ImpressionService.RecordImpression(impression); err != nil {
+		if errors.Is(err, services.ErrQueueFull) {
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "Too many impressions in flight, try again later",
+			})
+		}
+		s.Log.Logger.Errorf("Failed to record impression: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record impression",
+		})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(response)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// handleMetrics serves every registered Prometheus metric in the text exposition format
+var handleMetrics = adaptor.HTTPHandler(promhttp.Handler())
+
+// unmatchedRoute labels requests no route matched, so unknown paths don't each get a series
+const unmatchedRoute = "unmatched"
+
+// recordRequest counts every request and times it by the route pattern that served it
+func recordRequest(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	// the error handler sets the status of a failed request after the middleware returns
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = fiber.StatusInternalServerError
+		var ferr *fiber.Error
+		if errors.As(err, &ferr) {
+			status = ferr.Code
+		}
+	}
+	route := c.Route().Path
+	if status == fiber.StatusNotFound && route == "/" && c.Path() != "/" {
+		route = unmatchedRoute
+	}
+	metrics.HTTPRequests.WithLabelValues(route, c.Method(), strconv.Itoa(status)).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(route, c.Method()).Observe(time.Since(start).Seconds())
+	return err
+}
@@ -1,13 +1,93 @@
 package server
 
+import (
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+)
+
 func (s *HttpServer) RegisterRoutes() {
+	s.App.Use(recordRequest)
+
 	api := s.App.Group("/ads")
 	// GET /ads
 	api.Get("/", s.GetAds)
+	// POST /ads
+	api.Post("/", s.handleCreateAd)
 	// POST /ads/click
 	api.Post("/click", s.handleRecordClick)
+	// POST /ads/impression
+	api.Post("/impression", s.handleRecordImpression)
+	// POST /ads/conversion
+	api.Post("/conversion", s.handleRecordConversion)
+	// GET /ads/:id
+	api.Get("/:id", s.handleGetAd)
+	// PATCH /ads/:id
+	api.Patch("/:id", s.handleUpdateAd)
+	// DELETE /ads/:id
+	api.Delete("/:id", s.handleDeleteAd)
+	// POST /ads/:id/restore
+	api.Post("/:id/restore", s.handleRestoreAd)
+	// GET /ads/:id/go
+	api.Get("/:id/go", s.handleClickRedirect)
 	// GET /ads/:id/clicks
 	api.Get("/:id/clicks", s.handleGetClickCount)
+	// GET /ads/:id/clicks/flagged
+	api.Get("/:id/clicks/flagged", s.handleGetFlaggedClicks)
 	// GET /ads/:id/analytics
 	api.Get("/:id/analytics", s.handleGetClickAnalytics)
+	// GET /ads/:id/analytics/timeseries
+	api.Get("/:id/analytics/timeseries", s.handleGetClickTimeSeries)
+
+	campaigns := s.App.Group("/campaigns")
+	// GET /campaigns
+	campaigns.Get("/", s.handleListCampaigns)
+	// POST /campaigns
+	campaigns.Post("/", s.handleCreateCampaign)
+	// GET /campaigns/:id
+	campaigns.Get("/:id", s.handleGetCampaign)
+	// PATCH /campaigns/:id
+	campaigns.Patch("/:id", s.handleUpdateCampaign)
+	// DELETE /campaigns/:id
+	campaigns.Delete("/:id", s.handleDeleteCampaign)
+	// GET /campaigns/:id/analytics
+	campaigns.Get("/:id/analytics", s.handleGetCampaignAnalytics)
+
+	advertisers := s.App.Group("/advertisers")
+	// GET /advertisers
+	advertisers.Get("/", s.handleListAdvertisers)
+	// POST /advertisers
+	advertisers.Post("/", s.handleCreateAdvertiser)
+	// GET /advertisers/:id
+	advertisers.Get("/:id", s.handleGetAdvertiser)
+	// PATCH /advertisers/:id
+	advertisers.Patch("/:id", s.handleUpdateAdvertiser)
+	// DELETE /advertisers/:id
+	advertisers.Delete("/:id", s.handleDeleteAdvertiser)
+	// GET /advertisers/:id/analytics
+	advertisers.Get("/:id/analytics", s.handleGetAdvertiserAnalytics)
+
+	admin := s.App.Group("/admin", s.requireAdmin)
+	// GET /admin/dlq/clicks
+	admin.Get("/dlq/clicks", s.handleGetDeadLetters)
+	// POST /admin/dlq/clicks/:partition/:offset/replay
+	admin.Post("/dlq/clicks/:partition/:offset/replay", s.handleReplayDeadLetter)
+	// GET /admin/consumers
+	admin.Get("/consumers", s.handleGetConsumers)
+	// GET /admin/circuit-breakers
+	admin.Get("/circuit-breakers", s.handleGetCircuitBreakers)
+	// GET /admin/circuit-breakers/:name
+	admin.Get("/circuit-breakers/:name", s.handleGetCircuitBreaker)
+	// POST /admin/circuit-breakers/:name/force-open
+	admin.Post("/circuit-breakers/:name/force-open", s.handleControlCircuitBreaker("forced open", (*circuitbreaker.CircuitBreaker).ForceOpen))
+	// POST /admin/circuit-breakers/:name/force-close
+	admin.Post("/circuit-breakers/:name/force-close", s.handleControlCircuitBreaker("forced closed", (*circuitbreaker.CircuitBreaker).ForceClose))
+	// POST /admin/circuit-breakers/:name/reset
+	admin.Post("/circuit-breakers/:name/reset", s.handleControlCircuitBreaker("reset", (*circuitbreaker.CircuitBreaker).Reset))
+
+	// GET /healthz
+	s.App.Get("/healthz", s.handleHealthz)
+	// GET /readyz
+	s.App.Get("/readyz", s.handleReadyz)
+
+	// GET /metrics
+	s.App.Get("/metrics", handleMetrics)
 }
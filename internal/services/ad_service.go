@@ -1,40 +1,322 @@
 package services
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ArjunMalhotra/internal/model"
 	"github.com/ArjunMalhotra/internal/repo"
 	"github.com/ArjunMalhotra/pkg/circuitbreaker"
 	"github.com/ArjunMalhotra/pkg/logger"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxURLLength    = 2048
+	maxUTMLength    = 255
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxRecentClicks = 50
+)
+
+var (
+	// ErrInvalidAd is returned when an ad payload fails validation
+	ErrInvalidAd = errors.New("invalid ad")
+	// ErrInvalidAdQuery is returned when listing options are malformed
+	ErrInvalidAdQuery = errors.New("invalid ad query")
 )
 
 type AdService struct {
-	adRepo *repo.AdRepo
-	log    *logger.Logger
-	cb     *circuitbreaker.CircuitBreaker
+	adRepo       *repo.AdRepo
+	campaignRepo *repo.CampaignRepo
+	log          *logger.Logger
+	cb           *circuitbreaker.CircuitBreaker
 }
 
-func NewAdService(adRepo *repo.AdRepo, log *logger.Logger) *AdService {
+func NewAdService(adRepo *repo.AdRepo, campaignRepo *repo.CampaignRepo, log *logger.Logger, breakers *circuitbreaker.Registry) *AdService {
 	return &AdService{
-		adRepo: adRepo,
-		log:    log,
-		cb:     circuitbreaker.NewCircuitBreaker(5, 30*time.Second, "ad-service"),
+		adRepo:       adRepo,
+		campaignRepo: campaignRepo,
+		log:          log,
+		cb:           breakers.Get(BreakerAds),
+	}
+}
+
+// ListAds returns one page of ads. Clicks are only attached when the caller asks for recent clicks.
+func (s *AdService) ListAds(q model.AdListQuery) (*model.AdPage, error) {
+	if q.SortBy == "" {
+		q.SortBy = "created_at"
+	}
+	if q.SortBy != "created_at" && q.SortBy != "total_clicks" {
+		return nil, fmt.Errorf("%w: sort must be created_at or total_clicks", ErrInvalidAdQuery)
+	}
+	if q.PageSize <= 0 {
+		q.PageSize = defaultPageSize
+	}
+	if q.PageSize > maxPageSize {
+		q.PageSize = maxPageSize
+	}
+	if q.RecentClicks > maxRecentClicks {
+		q.RecentClicks = maxRecentClicks
+	}
+	after, err := decodeAdCursor(q.Cursor, q.SortBy)
+	if err != nil {
+		return nil, err
+	}
+
+	// fetch one extra row to know whether there is a next page
+	ads, err := circuitbreaker.ExecuteT(context.Background(), s.cb, func(context.Context) ([]model.Ad, error) {
+		return s.adRepo.List(q, after, q.PageSize+1)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.AdPage{
+		Ads:    ads,
+		Paging: model.Paging{PageSize: q.PageSize},
+	}
+	if len(ads) > q.PageSize {
+		page.Ads = ads[:q.PageSize]
+		page.Paging.HasMore = true
+		page.Paging.NextCursor = encodeAdCursor(page.Ads[len(page.Ads)-1], q.SortBy)
+	}
+	page.Paging.Count = len(page.Ads)
+
+	if q.RecentClicks > 0 && len(page.Ads) > 0 {
+		ids := make([]string, len(page.Ads))
+		for i, ad := range page.Ads {
+			ids[i] = ad.ID
+		}
+		clicks, err := circuitbreaker.ExecuteT(context.Background(), s.cb, func(context.Context) (map[string][]model.Click, error) {
+			return s.adRepo.FetchRecentClicks(ids, q.RecentClicks)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Ads {
+			page.Ads[i].Clicks = clicks[page.Ads[i].ID]
+		}
+	}
+	return page, nil
+}
+
+// adCursor is the opaque cursor handed out to clients, base64 encoded JSON
+type adCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func encodeAdCursor(ad model.Ad, sortBy string) string {
+	c := adCursor{ID: ad.ID}
+	if sortBy == "total_clicks" {
+		c.Value = strconv.Itoa(ad.TotalClicks)
+	} else {
+		c.Value = ad.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeAdCursor(raw string, sortBy string) (*repo.AdCursor, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidAdQuery)
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, invalid
 	}
+	var c adCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, invalid
+	}
+	if sortBy == "total_clicks" {
+		v, err := strconv.Atoi(c.Value)
+		if err != nil {
+			return nil, invalid
+		}
+		return &repo.AdCursor{Value: v, ID: c.ID}, nil
+	}
+	v, err := time.Parse(time.RFC3339Nano, c.Value)
+	if err != nil {
+		return nil, invalid
+	}
+	return &repo.AdCursor{Value: v, ID: c.ID}, nil
+}
+
+func (s *AdService) GetAd(id string) (*model.Ad, error) {
+	ad, err := circuitbreaker.ExecuteT(context.Background(), s.cb, func(context.Context) (*model.Ad, error) {
+		return s.adRepo.FetchByID(id, false)
+	})
+	return ad, err
 }
 
-func (s *AdService) GetAllAds() ([]model.Ad, error) {
-	if s.cb.IsOpen() {
-		return nil, fmt.Errorf("circuit breaker is open for ad-service")
+func (s *AdService) CreateAd(input model.AdInput) (*model.Ad, error) {
+	if input.ImageURL == nil || input.TargetURL == nil {
+		return nil, fmt.Errorf("%w: image_url and target_url are required", ErrInvalidAd)
+	}
+	if err := validateAdURL("image_url", *input.ImageURL); err != nil {
+		return nil, err
 	}
-	ads, err := s.adRepo.FetchAll()
+	if err := validateAdURL("target_url", *input.TargetURL); err != nil {
+		return nil, err
+	}
+	utm, err := utmFields(input)
 	if err != nil {
-		s.cb.RecordFailure()
 		return nil, err
 	}
-	s.cb.RecordSuccess()
-	return ads, nil
+	ad := &model.Ad{
+		ID:          uuid.New().String(),
+		ImageURL:    *input.ImageURL,
+		TargetURL:   *input.TargetURL,
+		UTMSource:   utm["utm_source"],
+		UTMMedium:   utm["utm_medium"],
+		UTMCampaign: utm["utm_campaign"],
+		UTMTerm:     utm["utm_term"],
+		UTMContent:  utm["utm_content"],
+	}
+	if input.CampaignID != nil && *input.CampaignID != "" {
+		if err := s.checkCampaign(*input.CampaignID); err != nil {
+			return nil, err
+		}
+		ad.CampaignID = input.CampaignID
+	}
+	if err := s.cb.Execute(context.Background(), func(context.Context) error { return s.adRepo.Create(ad) }); err != nil {
+		return nil, err
+	}
+	return ad, nil
+}
+
+func (s *AdService) UpdateAd(id string, input model.AdInput) (*model.Ad, error) {
+	fields := map[string]interface{}{}
+	if input.ImageURL != nil {
+		if err := validateAdURL("image_url", *input.ImageURL); err != nil {
+			return nil, err
+		}
+		fields["image_url"] = *input.ImageURL
+	}
+	if input.TargetURL != nil {
+		if err := validateAdURL("target_url", *input.TargetURL); err != nil {
+			return nil, err
+		}
+		fields["target_url"] = *input.TargetURL
+	}
+	utm, err := utmFields(input)
+	if err != nil {
+		return nil, err
+	}
+	for column, value := range utm {
+		fields[column] = value
+	}
+	if input.CampaignID != nil {
+		if *input.CampaignID == "" {
+			// an empty campaign detaches the ad
+			fields["campaign_id"] = nil
+		} else {
+			if err := s.checkCampaign(*input.CampaignID); err != nil {
+				return nil, err
+			}
+			fields["campaign_id"] = *input.CampaignID
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: nothing to update", ErrInvalidAd)
+	}
+	if err := s.cb.Execute(context.Background(), func(context.Context) error { return s.adRepo.Update(id, fields) }); err != nil {
+		return nil, err
+	}
+	return s.GetAd(id)
+}
+
+// ResolveRedirect returns a live ad together with the URL a click on it should land on:
+// its TargetURL with the ad's UTM parameters appended
+func (s *AdService) ResolveRedirect(id string) (*model.Ad, string, error) {
+	ad, err := s.GetAd(id)
+	if err != nil {
+		return nil, "", err
+	}
+	target, err := url.Parse(ad.TargetURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("ad %s has an unparsable target_url: %w", id, err)
+	}
+	query := target.Query()
+	for key, value := range ad.UTMParams() {
+		// parameters already baked into the target url win
+		if !query.Has(key) {
+			query.Set(key, value)
+		}
+	}
+	target.RawQuery = query.Encode()
+	return ad, target.String(), nil
+}
+
+func (s *AdService) DeleteAd(id string) error {
+	return s.cb.Execute(context.Background(), func(context.Context) error { return s.adRepo.Delete(id) })
+}
+
+func (s *AdService) RestoreAd(id string) (*model.Ad, error) {
+	if err := s.cb.Execute(context.Background(), func(context.Context) error { return s.adRepo.Restore(id) }); err != nil {
+		return nil, err
+	}
+	return s.GetAd(id)
+}
+
+// checkCampaign makes sure an ad is only attached to a live campaign
+func (s *AdService) checkCampaign(campaignID string) error {
+	err := s.cb.Execute(context.Background(), func(context.Context) error {
+		_, err := s.campaignRepo.FetchByID(campaignID)
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: campaign %s does not exist", ErrInvalidAd, campaignID)
+	}
+	return err
+}
+
+// utmFields collects the UTM parameters set in the input keyed by column name.
+// An empty string clears a parameter on update.
+func utmFields(input model.AdInput) (map[string]string, error) {
+	fields := make(map[string]string)
+	for column, value := range map[string]*string{
+		"utm_source":   input.UTMSource,
+		"utm_medium":   input.UTMMedium,
+		"utm_campaign": input.UTMCampaign,
+		"utm_term":     input.UTMTerm,
+		"utm_content":  input.UTMContent,
+	} {
+		if value == nil {
+			continue
+		}
+		if len(*value) > maxUTMLength {
+			return nil, fmt.Errorf("%w: %s must be at most %d characters", ErrInvalidAd, column, maxUTMLength)
+		}
+		fields[column] = strings.TrimSpace(*value)
+	}
+	return fields, nil
+}
+
+// validateAdURL checks that an ad URL is an absolute http(s) URL that fits the column
+func validateAdURL(field, raw string) error {
+	if strings.TrimSpace(raw) == "" {
+		return fmt.Errorf("%w: %s is required", ErrInvalidAd, field)
+	}
+	if len(raw) > maxURLLength {
+		return fmt.Errorf("%w: %s must be at most %d characters", ErrInvalidAd, field, maxURLLength)
+	}
+	u, err := url.ParseRequestURI(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("%w: %s must be an absolute http or https URL", ErrInvalidAd, field)
+	}
+	return nil
 }
 
 // ParseTimeframe parses a timeframe string in the format "int+h/d" (e.g., "56h", "3d")
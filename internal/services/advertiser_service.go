@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/repo"
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/ArjunMalhotra/pkg/logger"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidAdvertiser is returned when an advertiser payload fails validation
+var ErrInvalidAdvertiser = errors.New("invalid advertiser")
+
+type AdvertiserService struct {
+	advertiserRepo *repo.AdvertiserRepo
+	campaignRepo   *repo.CampaignRepo
+	adRepo         *repo.AdRepo
+	clickRepo      *repo.ClickRepo
+	log            *logger.Logger
+	cb             *circuitbreaker.CircuitBreaker
+}
+
+func NewAdvertiserService(advertiserRepo *repo.AdvertiserRepo, campaignRepo *repo.CampaignRepo, adRepo *repo.AdRepo, clickRepo *repo.ClickRepo, log *logger.Logger, breakers *circuitbreaker.Registry) *AdvertiserService {
+	return &AdvertiserService{
+		advertiserRepo: advertiserRepo,
+		campaignRepo:   campaignRepo,
+		adRepo:         adRepo,
+		clickRepo:      clickRepo,
+		log:            log,
+		cb:             breakers.Get(BreakerAdvertisers),
+	}
+}
+
+func (s *AdvertiserService) ListAdvertisers() ([]model.Advertiser, error) {
+	advertisers, err := circuitbreaker.ExecuteT(context.Background(), s.cb, func(context.Context) ([]model.Advertiser, error) {
+		return s.advertiserRepo.FetchAll()
+	})
+	return advertisers, err
+}
+
+func (s *AdvertiserService) GetAdvertiser(id string) (*model.Advertiser, error) {
+	advertiser, err := circuitbreaker.ExecuteT(context.Background(), s.cb, func(context.Context) (*model.Advertiser, error) {
+		return s.advertiserRepo.FetchByID(id)
+	})
+	return advertiser, err
+}
+
+func (s *AdvertiserService) CreateAdvertiser(input model.AdvertiserInput) (*model.Advertiser, error) {
+	if input.Name == nil {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidAdvertiser)
+	}
+	advertiser := &model.Advertiser{ID: uuid.New().String()}
+	if err := applyAdvertiserInput(advertiser, input); err != nil {
+		return nil, err
+	}
+	if err := s.cb.Execute(context.Background(), func(context.Context) error { return s.advertiserRepo.Create(advertiser) }); err != nil {
+		return nil, err
+	}
+	return advertiser, nil
+}
+
+func (s *AdvertiserService) UpdateAdvertiser(id string, input model.AdvertiserInput) (*model.Advertiser, error) {
+	advertiser, err := s.GetAdvertiser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyAdvertiserInput(advertiser, input); err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{
+		"name":  advertiser.Name,
+		"email": advertiser.Email,
+	}
+	if err := s.cb.Execute(context.Background(), func(context.Context) error { return s.advertiserRepo.Update(id, fields) }); err != nil {
+		return nil, err
+	}
+	return s.GetAdvertiser(id)
+}
+
+func (s *AdvertiserService) DeleteAdvertiser(id string) error {
+	return s.cb.Execute(context.Background(), func(context.Context) error { return s.advertiserRepo.Delete(id) })
+}
+
+// GetAdvertiserAnalytics rolls ad clicks up through campaigns to the advertiser.
+// The breakdown is keyed by campaign ID.
+func (s *AdvertiserService) GetAdvertiserAnalytics(id string, timeFrame string) (model.ClickStats, map[string]model.ClickStats, error) {
+	duration, err := parseTimeFrame(timeFrame)
+	if err != nil {
+		return model.ClickStats{}, nil, fmt.Errorf("%w: %v", ErrInvalidAdvertiser, err)
+	}
+	if _, err := s.GetAdvertiser(id); err != nil {
+		return model.ClickStats{}, nil, err
+	}
+	var campaignIDs []string
+	var ads []model.Ad
+	var counts map[string]int64
+	err = s.cb.Execute(context.Background(), func(context.Context) error {
+		var err error
+		if campaignIDs, err = s.campaignRepo.FetchIDsByAdvertiser(id); err != nil {
+			return err
+		}
+		if ads, err = s.adRepo.FetchByCampaigns(campaignIDs); err != nil {
+			return err
+		}
+		counts, err = s.clickRepo.GetClickCountsByAds(adIDs(ads), duration)
+		return err
+	})
+	if err != nil {
+		return model.ClickStats{}, nil, err
+	}
+	total, byCampaign := rollupClicks(ads, counts, func(ad model.Ad) string { return *ad.CampaignID })
+	// campaigns without ads still show up in the breakdown
+	for _, campaignID := range campaignIDs {
+		if _, ok := byCampaign[campaignID]; !ok {
+			byCampaign[campaignID] = model.ClickStats{}
+		}
+	}
+	return total, byCampaign, nil
+}
+
+func applyAdvertiserInput(advertiser *model.Advertiser, input model.AdvertiserInput) error {
+	if input.Name != nil {
+		advertiser.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Email != nil {
+		advertiser.Email = strings.TrimSpace(*input.Email)
+	}
+	if advertiser.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidAdvertiser)
+	}
+	if advertiser.Email != "" {
+		if _, err := mail.ParseAddress(advertiser.Email); err != nil {
+			return fmt.Errorf("%w: email is not a valid address", ErrInvalidAdvertiser)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/repo"
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/ArjunMalhotra/pkg/logger"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCampaign is returned when a campaign payload fails validation
+var ErrInvalidCampaign = errors.New("invalid campaign")
+
+type CampaignService struct {
+	campaignRepo   *repo.CampaignRepo
+	advertiserRepo *repo.AdvertiserRepo
+	adRepo         *repo.AdRepo
+	clickRepo      *repo.ClickRepo
+	log            *logger.Logger
+	cb             *circuitbreaker.CircuitBreaker
+}
+
+func NewCampaignService(campaignRepo *repo.CampaignRepo, advertiserRepo *repo.AdvertiserRepo, adRepo *repo.AdRepo, clickRepo *repo.ClickRepo, log *logger.Logger, breakers *circuitbreaker.Registry) *CampaignService {
+	return &CampaignService{
+		campaignRepo:   campaignRepo,
+		advertiserRepo: advertiserRepo,
+		adRepo:         adRepo,
+		clickRepo:      clickRepo,
+		log:            log,
+		cb:             breakers.Get(BreakerCampaigns),
+	}
+}
+
+func (s *CampaignService) ListCampaigns(advertiserID, status string) ([]model.Campaign, error) {
+	if status != "" && !validCampaignStatus(status) {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidCampaign, status)
+	}
+	campaigns, err := circuitbreaker.ExecuteT(context.Background(), s.cb, func(context.Context) ([]model.Campaign, error) {
+		return s.campaignRepo.FetchAll(advertiserID, status)
+	})
+	return campaigns, err
+}
+
+func (s *CampaignService) GetCampaign(id string) (*model.Campaign, error) {
+	campaign, err := circuitbreaker.ExecuteT(context.Background(), s.cb, func(context.Context) (*model.Campaign, error) {
+		return s.campaignRepo.FetchByID(id)
+	})
+	return campaign, err
+}
+
+func (s *CampaignService) CreateCampaign(input model.CampaignInput) (*model.Campaign, error) {
+	if input.AdvertiserID == nil || *input.AdvertiserID == "" {
+		return nil, fmt.Errorf("%w: advertiser_id is required", ErrInvalidCampaign)
+	}
+	if input.Name == nil {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidCampaign)
+	}
+	campaign := &model.Campaign{
+		ID:           uuid.New().String(),
+		AdvertiserID: *input.AdvertiserID,
+		Status:       model.CampaignStatusDraft,
+	}
+	applyCampaignInput(campaign, input)
+	if err := validateCampaign(campaign); err != nil {
+		return nil, err
+	}
+	if err := s.checkAdvertiser(campaign.AdvertiserID); err != nil {
+		return nil, err
+	}
+	if err := s.cb.Execute(context.Background(), func(context.Context) error { return s.campaignRepo.Create(campaign) }); err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+func (s *CampaignService) UpdateCampaign(id string, input model.CampaignInput) (*model.Campaign, error) {
+	campaign, err := s.GetCampaign(id)
+	if err != nil {
+		return nil, err
+	}
+	if input.AdvertiserID != nil && *input.AdvertiserID != campaign.AdvertiserID {
+		return nil, fmt.Errorf("%w: a campaign cannot move to another advertiser", ErrInvalidCampaign)
+	}
+	applyCampaignInput(campaign, input)
+	// validate the merged record so date ranges are checked against stored values
+	if err := validateCampaign(campaign); err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{
+		"name":       campaign.Name,
+		"budget":     campaign.Budget,
+		"start_date": campaign.StartDate,
+		"end_date":   campaign.EndDate,
+		"status":     campaign.Status,
+	}
+	if err := s.cb.Execute(context.Background(), func(context.Context) error { return s.campaignRepo.Update(id, fields) }); err != nil {
+		return nil, err
+	}
+	return s.GetCampaign(id)
+}
+
+func (s *CampaignService) DeleteCampaign(id string) error {
+	return s.cb.Execute(context.Background(), func(context.Context) error { return s.campaignRepo.Delete(id) })
+}
+
+// GetCampaignAnalytics rolls the click counts of every ad in the campaign up to the campaign.
+// The breakdown is keyed by ad ID.
+func (s *CampaignService) GetCampaignAnalytics(id string, timeFrame string) (model.ClickStats, map[string]model.ClickStats, error) {
+	duration, err := parseTimeFrame(timeFrame)
+	if err != nil {
+		return model.ClickStats{}, nil, fmt.Errorf("%w: %v", ErrInvalidCampaign, err)
+	}
+	if _, err := s.GetCampaign(id); err != nil {
+		return model.ClickStats{}, nil, err
+	}
+	var ads []model.Ad
+	var counts map[string]int64
+	err = s.cb.Execute(context.Background(), func(context.Context) error {
+		var err error
+		if ads, err = s.adRepo.FetchByCampaigns([]string{id}); err != nil {
+			return err
+		}
+		counts, err = s.clickRepo.GetClickCountsByAds(adIDs(ads), duration)
+		return err
+	})
+	if err != nil {
+		return model.ClickStats{}, nil, err
+	}
+	total, byAd := rollupClicks(ads, counts, func(ad model.Ad) string { return ad.ID })
+	return total, byAd, nil
+}
+
+func (s *CampaignService) checkAdvertiser(advertiserID string) error {
+	err := s.cb.Execute(context.Background(), func(context.Context) error {
+		_, err := s.advertiserRepo.FetchByID(advertiserID)
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: advertiser %s does not exist", ErrInvalidCampaign, advertiserID)
+	}
+	return err
+}
+
+func applyCampaignInput(campaign *model.Campaign, input model.CampaignInput) {
+	if input.Name != nil {
+		campaign.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Budget != nil {
+		campaign.Budget = *input.Budget
+	}
+	if input.StartDate.Set {
+		campaign.StartDate = input.StartDate.Time
+	}
+	if input.EndDate.Set {
+		campaign.EndDate = input.EndDate.Time
+	}
+	if input.Status != nil {
+		campaign.Status = *input.Status
+	}
+}
+
+func validateCampaign(campaign *model.Campaign) error {
+	if campaign.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCampaign)
+	}
+	if campaign.Budget < 0 {
+		return fmt.Errorf("%w: budget cannot be negative", ErrInvalidCampaign)
+	}
+	if campaign.Budget > model.MaxMoney {
+		return fmt.Errorf("%w: budget cannot be more than %s", ErrInvalidCampaign, model.MaxMoney)
+	}
+	if campaign.StartDate != nil && campaign.EndDate != nil && !campaign.EndDate.After(*campaign.StartDate) {
+		return fmt.Errorf("%w: end_date must be after start_date", ErrInvalidCampaign)
+	}
+	if !validCampaignStatus(campaign.Status) {
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidCampaign, campaign.Status)
+	}
+	return nil
+}
+
+func validCampaignStatus(status string) bool {
+	switch status {
+	case model.CampaignStatusDraft, model.CampaignStatusActive, model.CampaignStatusPaused, model.CampaignStatusCompleted:
+		return true
+	}
+	return false
+}
+
+func adIDs(ads []model.Ad) []string {
+	ids := make([]string, len(ads))
+	for i, ad := range ads {
+		ids[i] = ad.ID
+	}
+	return ids
+}
+
+// rollupClicks sums per-ad click counts into a total and into buckets chosen by groupBy
+func rollupClicks(ads []model.Ad, counts map[string]int64, groupBy func(model.Ad) string) (model.ClickStats, map[string]model.ClickStats) {
+	var total model.ClickStats
+	groups := make(map[string]model.ClickStats)
+	for _, ad := range ads {
+		key := groupBy(ad)
+		group := groups[key]
+		group.Clicks += counts[ad.ID]
+		group.TotalClicks += int64(ad.TotalClicks)
+		groups[key] = group
+
+		total.Clicks += counts[ad.ID]
+		total.TotalClicks += int64(ad.TotalClicks)
+	}
+	return total, groups
+}
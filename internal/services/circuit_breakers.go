@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ArjunMalhotra/config"
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/ArjunMalhotra/pkg/logger"
+	"gorm.io/gorm"
+)
+
+// names of the services' circuit breakers, one per service guarding its repo calls
+const (
+	BreakerAds         = "ad-service"
+	BreakerAdvertisers = "advertiser-service"
+	BreakerCampaigns   = "campaign-service"
+	BreakerClicks      = "click-service"
+	BreakerConversions = "conversion-service"
+	BreakerImpressions = "impression-service"
+)
+
+// breakerNames lists the circuit breakers config overrides may name
+var breakerNames = []string{BreakerAds, BreakerAdvertisers, BreakerCampaigns, BreakerClicks, BreakerConversions, BreakerImpressions}
+
+// NewBreakerRegistry returns the registry the services get their circuit breakers from, with
+// the thresholds of cfg. A missing record is a valid answer from the DB and doesn't count as a
+// failure, and state changes are logged. Overrides of breakers that don't exist are rejected.
+func NewBreakerRegistry(cfg config.CircuitBreakerConfig, log *logger.Logger) (*circuitbreaker.Registry, error) {
+	overrides := make(map[string]circuitbreaker.Settings, len(cfg.Overrides))
+	for name, breaker := range cfg.Overrides {
+		if !slices.Contains(breakerNames, name) {
+			return nil, fmt.Errorf("circuit breaker override for unknown breaker %q, use one of %s", name, strings.Join(breakerNames, ", "))
+		}
+		overrides[name] = breakerSettings(breaker)
+	}
+	return circuitbreaker.NewRegistry(breakerSettings(cfg.Defaults), overrides,
+		circuitbreaker.WithFailureClassifier(func(err error) bool {
+			return !errors.Is(err, gorm.ErrRecordNotFound)
+		}),
+		circuitbreaker.WithStateChange(func(name string, from, to circuitbreaker.State) {
+			log.Logger.Warnf("Circuit breaker %s moved from %s to %s", name, from, to)
+		}),
+	), nil
+}
+
+func breakerSettings(cfg config.BreakerConfig) circuitbreaker.Settings {
+	return circuitbreaker.Settings{
+		FailureThreshold:  cfg.FailureThreshold,
+		ResetTimeout:      cfg.ResetTimeout,
+		MaxResetTimeout:   cfg.MaxResetTimeout,
+		FailureRate:       cfg.FailureRate,
+		Window:            cfg.Window,
+		MinRequests:       cfg.MinRequests,
+		HalfOpenProbes:    cfg.HalfOpenProbes,
+		SlowCallThreshold: cfg.SlowCallThreshold,
+	}
+}
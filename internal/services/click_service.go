@@ -1,175 +1,535 @@
 package services
 
 import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ArjunMalhotra/config"
+	"github.com/ArjunMalhotra/internal/events"
+	"github.com/ArjunMalhotra/internal/metrics"
 	"github.com/ArjunMalhotra/internal/model"
 	"github.com/ArjunMalhotra/internal/repo"
 	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/ArjunMalhotra/pkg/counter"
+	"github.com/ArjunMalhotra/pkg/dedup"
 	"github.com/ArjunMalhotra/pkg/logger"
+	"github.com/go-sql-driver/mysql"
 	"gorm.io/gorm"
 )
 
-const (
-	batchSize = 100
-)
+const maxTimeSeriesBuckets = 1440
+
+// ErrInvalidTimeSeries is returned when a time series request can't be served
+var ErrInvalidTimeSeries = errors.New("invalid time series request")
+
+// ErrDeadLetterNotFound is returned when there is no dead letter at a partition and offset
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
 
 type ClickService struct {
 	clickRepo *repo.ClickRepo
 	log       *logger.Logger
 	cb        *circuitbreaker.CircuitBreaker
-	counters  map[string]*CounterEntry
-	kafka     *KafkaService
+	counters  counter.Counter
+	bus       EventBus
+	topics    config.TopicsConfig
+	codec     *events.ClickCodec
+	fraud     *FraudDetector
+	pending   *PendingClicks // the clicks in the batches, for the fraud rules
+	processed dedup.Store    // IDs of clicks already consumed
+	retry     ClickRetryPolicy
+	consumers []Subscription
+
+	batchSize     int
+	flushInterval time.Duration
+	batchesMutex  sync.Mutex
+	batches       map[string]*clickBatch // by partition
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Delivery is where a consumed click came from and how to acknowledge it
+type Delivery struct {
+	// the topic and partition, clicks are batched per partition
+	Partition string
+	// how often the click was consumed, from 1
+	Attempt int
+	// the message as consumed, passed on as is when the click is retried
+	Value []byte
+	// acks the message once the click is committed or handed back to the event bus
+	Ack func()
+}
+
+// pendingClick is a consumed click waiting in its batch
+type pendingClick struct {
+	click    model.Click
+	delivery Delivery
+}
+
+// clickBatch holds the clicks consumed from one partition, so the increments of an ad add up
+// within its batch. An ad can still be in several batches at once (retried clicks, or a random
+// or round-robin partitioner), so flushes may wait on each other's rows; SaveBatch updates ads
+// in sorted order so they can't deadlock.
+type clickBatch struct {
+	mutex   sync.Mutex
+	pending []pendingClick
+}
 
-	batchMutex   sync.Mutex
-	counterMutex sync.RWMutex
-	currentBatch []model.Click
-	processedIDs sync.Map // Track processed click IDs
+// ClickRetryPolicy decides how clicks that failed are retried through the retry topic
+type ClickRetryPolicy struct {
+	// attempts, including the first, before a click goes to the dead-letter topic
+	MaxAttempts int
+	// wait before the second attempt, doubled for every attempt after it
+	Delay time.Duration
 }
 
-type CounterEntry struct {
-	ClickCount int64
-	LastUpdate time.Time
+// backoff returns how long to wait before attempt
+func (p ClickRetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Delay
+	for i := 2; i < attempt && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return delay
 }
 
-func NewClickService(clickRepo *repo.ClickRepo, log *logger.Logger, kafka *KafkaService) *ClickService {
+// NewClickService starts consuming clicks. Batches are stored when they reach batchSize clicks
+// and at least every flushInterval, so clicks on a quiet ad don't wait for a full batch.
+func NewClickService(clickRepo *repo.ClickRepo, log *logger.Logger, breakers *circuitbreaker.Registry, bus EventBus, topics config.TopicsConfig, codec *events.ClickCodec, fraud *FraudDetector, pending *PendingClicks, counters counter.Counter, processed dedup.Store, batchSize int, flushInterval time.Duration, retry ClickRetryPolicy) *ClickService {
 	service := &ClickService{
-		clickRepo:    clickRepo,
-		log:          log,
-		cb:           circuitbreaker.NewCircuitBreaker(5, time.Second*30, "click-service"),
-		counters:     make(map[string]*CounterEntry),
-		kafka:        kafka,
-		currentBatch: make([]model.Click, 0, batchSize),
+		clickRepo:     clickRepo,
+		log:           log,
+		cb:            breakers.Get(BreakerClicks),
+		counters:      counters,
+		bus:           bus,
+		topics:        topics,
+		codec:         codec,
+		fraud:         fraud,
+		pending:       pending,
+		processed:     processed,
+		retry:         retry,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		batches:       make(map[string]*clickBatch),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
 	}
+	go service.runFlusher()
 
-	// Start Kafka consumer
-	if err := kafka.StartConsumer(service, 3); err != nil {
-		log.Logger.Errorf("Failed to start Kafka consumer: %v", err)
+	// Start consumers
+	consumer, err := bus.Subscribe(topics.Clicks.Group, topics.Clicks.Topic, topics.Clicks.Workers, func(workerID int) MessageHandler {
+		return &ClickConsumerHandler{clickService: service, log: log, workerID: workerID}
+	})
+	if err != nil {
+		log.Logger.Errorf("Failed to start click consumer: %v", err)
+	} else {
+		service.consumers = append(service.consumers, consumer)
+	}
+	retryConsumer, err := bus.Subscribe(topics.ClickRetries.Group, topics.ClickRetries.Topic, topics.ClickRetries.Workers, func(workerID int) MessageHandler {
+		return &ClickRetryConsumerHandler{ClickConsumerHandler{clickService: service, log: log, workerID: workerID}}
+	})
+	if err != nil {
+		log.Logger.Errorf("Failed to start click retry consumer: %v", err)
+	} else {
+		service.consumers = append(service.consumers, retryConsumer)
+	}
+	if err := bus.CreateTopic(topics.ClickDeadLetters); err != nil {
+		log.Logger.Warnf("Failed to create topic: %v", err)
 	}
 
 	return service
 }
 
-func (s *ClickService) ProcessClick(click model.Click) error {
-	// Check if we've already processed this click
-	if _, exists := s.processedIDs.LoadOrStore(click.ID, time.Now()); exists {
+// Shutdown stops consuming clicks and stores the clicks still in the batch. It returns early
+// with ctx's error if that takes too long.
+func (s *ClickService) Shutdown(ctx context.Context) error {
+	// closed first, so a worker stuck handing a click off gives up instead of holding up its
+	// consumer until ctx is done
+	s.stopOnce.Do(func() { close(s.stop) })
+	var err error
+	for _, consumer := range s.consumers {
+		// leaving the consumer group flushes the batch and commits its offsets
+		if stopErr := consumer.Stop(ctx); stopErr != nil {
+			err = fmt.Errorf("failed to stop click consumer: %w", stopErr)
+		}
+	}
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.flush()
+	return err
+}
+
+// runFlusher stores the batch every flushInterval until Shutdown
+func (s *ClickService) runFlusher() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush stores whatever is in the batches
+func (s *ClickService) flush() {
+	s.batchesMutex.Lock()
+	batches := make([]*clickBatch, 0, len(s.batches))
+	for _, batch := range s.batches {
+		batches = append(batches, batch)
+	}
+	s.batchesMutex.Unlock()
+
+	for _, batch := range batches {
+		batch.mutex.Lock()
+		if err := s.processBatch(batch); err != nil {
+			s.log.Logger.Errorf("Failed to flush click batch: %v", err)
+		}
+		batch.mutex.Unlock()
+	}
+}
+
+// batch returns the batch of a partition
+func (s *ClickService) batch(partition string) *clickBatch {
+	s.batchesMutex.Lock()
+	defer s.batchesMutex.Unlock()
+
+	batch, ok := s.batches[partition]
+	if !ok {
+		batch = &clickBatch{pending: make([]pendingClick, 0, s.batchSize)}
+		s.batches[partition] = batch
+	}
+	return batch
+}
+
+// ProcessClick scores a consumed click and adds it to the batch of its partition. The delivery
+// is acked once the click is stored and counted, dropped as a duplicate or handed to the retry
+// topic, so its Kafka offset is only committed then.
+func (s *ClickService) ProcessClick(click model.Click, delivery Delivery) error {
+	// Check if we've already processed this click, here or on another consumer
+	seen, err := s.processed.Seen(click.ID)
+	if err != nil {
+		// better to leave the click unprocessed than to risk counting it twice
+		return fmt.Errorf("failed to check click %s for duplicates: %w", click.ID, err)
+	}
+	if seen {
+		metrics.DuplicatesDropped.WithLabelValues(metrics.StreamClicks).Inc()
 		s.log.Logger.Debugf("Skipping duplicate click ID: %s", click.ID)
+		delivery.Ack()
 		return nil
 	}
 
-	// Update counters immediately for real-time stats
-	s.updateCounter(click)
-
-	// Update database counter immediately for accurate counts
-	if err := s.clickRepo.UpdateAdTotalClicks(click.AdID, 1); err != nil {
-		s.log.Logger.Errorf("Failed to update total clicks for ad %s: %v", click.AdID, err)
+	// Score the click, flagged clicks are stored but never counted
+	verdict := s.fraud.Evaluate(click)
+	click.FraudScore = verdict.Score
+	click.Flagged = verdict.Flagged
+	if verdict.Flagged {
+		click.FraudReason = strings.Join(verdict.Reasons, "; ")
+		s.log.Logger.Warnf("Flagged click %s on ad %s: %s", click.ID, click.AdID, click.FraudReason)
 	}
 
-	s.batchMutex.Lock()
-	defer s.batchMutex.Unlock()
+	batch := s.batch(delivery.Partition)
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
 
-	s.currentBatch = append(s.currentBatch, click)
-	if len(s.currentBatch) >= batchSize {
-		return s.processBatch()
+	batch.pending = append(batch.pending, pendingClick{click: click, delivery: delivery})
+	s.pending.add(click)
+	if len(batch.pending) >= s.batchSize {
+		return s.processBatch(batch)
 	}
 	return nil
 }
 
-func (s *ClickService) processBatch() error {
-	if len(s.currentBatch) == 0 {
+// processBatch stores a batch, handing the clicks that can't be stored to the retry topic.
+// Callers hold the batch's mutex.
+func (s *ClickService) processBatch(batch *clickBatch) error {
+	if len(batch.pending) == 0 {
 		return nil
 	}
-
-	if s.cb.IsOpen() {
-		// Circuit breaker open, save to Kafka for retry
-		for _, click := range s.currentBatch {
-			if err := s.kafka.PublishClick(click); err != nil {
-				s.log.Logger.Errorf("Failed to republish click to Kafka: %v", err)
-			}
+	metrics.BatchSize.WithLabelValues(metrics.StreamClicks).Observe(float64(len(batch.pending)))
+	start := time.Now()
+	defer func() {
+		metrics.BatchFlushDuration.WithLabelValues(metrics.StreamClicks).Observe(time.Since(start).Seconds())
+		for _, pending := range batch.pending {
+			s.pending.remove(pending.click)
 		}
-		s.currentBatch = s.currentBatch[:0]
+		batch.pending = batch.pending[:0]
+	}()
+
+	err := s.storeClicks(batch.pending)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, circuitbreaker.ErrOpen) {
+		s.log.Logger.Errorf("Failed to store batch in database: %v", err)
+	}
+	if len(batch.pending) == 1 || errors.Is(err, circuitbreaker.ErrOpen) || isConnectionError(err) {
+		// Nothing was counted, hand the batch to the retry topic
+		s.retryBatch(batch.pending, err)
 		return nil
 	}
+	// One bad click fails the whole insert, store the clicks one by one so only the bad
+	// ones are retried
+	for _, pending := range batch.pending {
+		if err := s.storeClicks([]pendingClick{pending}); err != nil {
+			s.retryBatch([]pendingClick{pending}, err)
+		}
+	}
+	return nil
+}
 
-	// Try database batch insert
-	err := s.clickRepo.SaveBatch(s.currentBatch)
+// storeClicks stores clicks and bumps total_clicks in one transaction, then updates the
+// counters and acks the clicks. Nothing is stored or acked when it fails.
+func (s *ClickService) storeClicks(pending []pendingClick) error {
+	clicks := make([]model.Click, len(pending))
+	for i, p := range pending {
+		clicks[i] = p.click
+	}
+
+	// Clicks that are already stored are skipped, so this is safe to retry
+	var inserted []model.Click
+	var totals map[string]int
+	err := s.cb.Execute(context.Background(), func(context.Context) error {
+		var err error
+		inserted, totals, err = s.clickRepo.SaveBatch(clicks)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			// another consumer stored one of the clicks after we checked, the retry skips it
+			inserted, totals, err = s.clickRepo.SaveBatch(clicks)
+		}
+		return err
+	})
 	if err != nil {
-		if err == gorm.ErrDuplicatedKey {
-			// Handle duplicate entries gracefully
-			s.log.Logger.Warnf("Duplicate entries in batch, skipping: %v", err)
-			s.currentBatch = s.currentBatch[:0]
-			return nil
+		return err
+	}
+
+	if skipped := len(clicks) - len(inserted); skipped > 0 {
+		metrics.DuplicatesDropped.WithLabelValues(metrics.StreamClicks).Add(float64(skipped))
+		s.log.Logger.Debugf("Skipped %d clicks that were already stored", skipped)
+	}
+	ids := make([]string, len(clicks))
+	for i, click := range clicks {
+		ids[i] = click.ID
+	}
+	if err := s.processed.Mark(ids...); err != nil {
+		// the database still rejects them, redeliveries just cost a query
+		s.log.Logger.Errorf("Failed to mark clicks as processed: %v", err)
+	}
+	s.updateCounters(inserted, totals)
+	s.reopenRollups(inserted)
+	for _, p := range pending {
+		p.delivery.Ack()
+	}
+	return nil
+}
+
+// reopenRollups has the rollup buckets of stored clicks compacted again if they were already,
+// which happens to clicks that took long to store, like retried or replayed ones
+func (s *ClickService) reopenRollups(clicks []model.Click) {
+	var oldest time.Time
+	for _, click := range clicks {
+		if !click.Flagged && (oldest.IsZero() || click.Timestamp.Before(oldest)) {
+			oldest = click.Timestamp
 		}
-		s.log.Logger.Errorf("Failed to store batch in database: %v", err)
-		s.cb.RecordFailure()
-		// Republish to Kafka for retry
-		for _, click := range s.currentBatch {
-			if err := s.kafka.PublishClick(click); err != nil {
-				s.log.Logger.Errorf("Failed to republish click to Kafka: %v", err)
-			}
+	}
+	if oldest.IsZero() {
+		return
+	}
+	if err := s.clickRepo.ReopenRollups(oldest); err != nil {
+		s.log.Logger.Errorf("Failed to reopen click rollups at %s: %v", oldest, err)
+	}
+}
+
+// isConnectionError reports whether err means the database couldn't be reached, rather than
+// that it rejected the clicks
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// retryBatch hands clicks to the retry topic and acks them. It stops at the first click that
+// can't be handed off, the clicks after it aren't acked either.
+func (s *ClickService) retryBatch(batch []pendingClick, reason error) {
+	for _, pending := range batch {
+		if err := handOff(s.stop, s.log, func() error { return s.retryClick(pending.click, pending.delivery, reason) }); err != nil {
+			s.log.Logger.Errorf("Gave up handing click %s back to the event bus: %v", pending.click.ID, err)
+			return
 		}
-	} else {
-		s.cb.RecordSuccess()
+		pending.delivery.Ack()
 	}
+}
 
-	// Clear the batch
-	s.currentBatch = s.currentBatch[:0]
-	return nil
+// retryClick sends the message of a click whose attempt failed to the retry topic with a growing
+// delay, or to the dead-letter topic once it has used up its attempts. Never to the clicks topic,
+// where a click that keeps failing would loop forever. The message is passed on as consumed, so
+// the event keeps its ID and encoding.
+func (s *ClickService) retryClick(click model.Click, delivery Delivery, reason error) error {
+	attempt := delivery.Attempt
+	if attempt >= s.retry.MaxAttempts {
+		s.log.Logger.Warnf("Giving up on click %s after %d attempts: %v", click.ID, attempt, reason)
+		return s.deadLetter(click.AdID, delivery.Value, attempt, reason)
+	}
+	next := attempt + 1
+	return s.bus.Publish(s.topics.ClickRetries.Topic, Message{
+		Key:   click.AdID,
+		Value: delivery.Value,
+		Headers: map[string]string{
+			headerAttempt: strconv.Itoa(next),
+			headerRetryAt: strconv.FormatInt(time.Now().Add(s.retry.backoff(next)).UnixMilli(), 10),
+			headerError:   reason.Error(),
+		},
+	})
 }
 
-func (s *ClickService) RecordClick(click model.Click) error {
-	return s.kafka.PublishClick(click)
+// deadLetter gives up on a click message after attempts and parks it on the dead-letter topic.
+// msg is passed as is since it may be what failed to parse, key is empty then.
+func (s *ClickService) deadLetter(key string, msg []byte, attempts int, reason error) error {
+	return s.bus.Publish(s.topics.ClickDeadLetters, Message{
+		Key:   key,
+		Value: msg,
+		Headers: map[string]string{
+			headerAttempt: strconv.Itoa(attempts),
+			headerError:   reason.Error(),
+		},
+	})
 }
 
-func (s *ClickService) updateCounter(click model.Click) {
-	adID := fmt.Sprintf("ad:%s", click.AdID)
+// GetDeadLetters returns up to limit clicks that were given up on
+func (s *ClickService) GetDeadLetters(limit int) ([]model.DeadLetter, error) {
+	messages, err := s.bus.Read(s.topics.ClickDeadLetters, limit)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]model.DeadLetter, len(messages))
+	for i, message := range messages {
+		value := string(message.Value)
+		if event, err := s.codec.Decode(message.Value); err == nil {
+			// Protobuf events are shown as JSON
+			if decoded, err := json.Marshal(event); err == nil {
+				value = string(decoded)
+			}
+		}
+		letters[i] = model.DeadLetter{
+			Partition: message.Partition,
+			Offset:    message.Offset,
+			Key:       message.Key,
+			Timestamp: message.Timestamp,
+			Attempts:  messageAttempt(message),
+			Error:     message.Headers[headerError],
+			Value:     value,
+		}
+	}
+	return letters, nil
+}
 
-	s.counterMutex.Lock()
-	defer s.counterMutex.Unlock()
+// ReplayDeadLetter publishes the dead letter at partition and offset to the clicks topic again,
+// as a first attempt. Clicks that were stored in the meantime are skipped by their ID, so
+// replaying twice is harmless.
+func (s *ClickService) ReplayDeadLetter(partition int32, offset int64) error {
+	message, err := s.bus.ReadAt(s.topics.ClickDeadLetters, partition, offset)
+	if errors.Is(err, ErrMessageNotFound) {
+		return ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.bus.Publish(s.topics.Clicks.Topic, Message{Key: message.Key, Value: message.Value})
+}
 
-	if entry, exists := s.counters[adID]; !exists {
-		s.counters[adID] = &CounterEntry{
-			ClickCount: 1,
-			LastUpdate: time.Now(),
+// RecordClick hands a click recorded by source to the event bus, as a click event, without
+// waiting for it to be stored. It fails with ErrQueueFull when the bus is backed up. Clicks are
+// keyed by their ad, so all clicks of an ad share a partition.
+func (s *ClickService) RecordClick(click model.Click, source string) error {
+	msg, err := s.codec.Encode(events.NewClickEvent(click, source))
+	if err != nil {
+		return fmt.Errorf("failed to encode click: %v", err)
+	}
+	return s.bus.Enqueue(s.topics.Clicks.Topic, Message{Key: click.AdID, Value: msg})
+}
+
+// updateCounters raises the cached totals to the ads' total_clicks as committed with newly
+// stored clicks and adds the unflagged ones to the minute buckets, one increment per ad and minute
+func (s *ClickService) updateCounters(clicks []model.Click, totals map[string]int) {
+	for adID, total := range totals {
+		if err := s.counters.Raise(clickCounterKey(adID), int64(total)); err != nil {
+			s.log.Logger.Errorf("Failed to update click counter for ad %s: %v", adID, err)
+		}
+	}
+	type adMinute struct {
+		adID   string
+		minute time.Time
+	}
+	increments := make(map[adMinute]int64)
+	for _, click := range clicks {
+		if !click.Flagged {
+			increments[adMinute{click.AdID, click.Timestamp.Truncate(time.Minute)}]++
+		}
+	}
+	for key, n := range increments {
+		if err := s.counters.Incr(clickCounterKey(key.adID), n, key.minute); err != nil {
+			s.log.Logger.Errorf("Failed to update click counter for ad %s: %v", key.adID, err)
 		}
-	} else {
-		entry.ClickCount++
-		entry.LastUpdate = time.Now()
 	}
 }
 
+// GetClickCount returns the total clicks of an ad from the shared counter, reading the
+// database and seeding the counter when it doesn't have the ad yet
 func (s *ClickService) GetClickCount(adID string) (int64, error) {
-	s.counterMutex.RLock()
-	defer s.counterMutex.RUnlock()
-
-	// First try to get from in-memory counter
-	id := fmt.Sprintf("ad:%s", adID)
-	if entry, exists := s.counters[id]; exists {
-		return entry.ClickCount, nil
+	key := clickCounterKey(adID)
+	count, ok, err := s.counters.Get(key)
+	if err != nil {
+		// the database still has the count, a counter outage only makes reads slower
+		s.log.Logger.Errorf("Failed to read click counter for ad %s: %v", adID, err)
+	}
+	if ok {
+		return count, nil
 	}
 
-	// If not in memory, get from database
 	totalClicks, err := s.clickRepo.GetAdTotalClicks(adID)
 	if err != nil {
 		return 0, err
 	}
-
-	// Update in-memory counter for future requests
-	s.counters[id] = &CounterEntry{
-		ClickCount: int64(totalClicks),
+	// a batch committed since the read has raised the total past it already
+	if err := s.counters.Raise(key, int64(totalClicks)); err != nil {
+		s.log.Logger.Errorf("Failed to seed click counter for ad %s: %v", adID, err)
 	}
-
 	return int64(totalClicks), nil
 }
 
+// GetRecentClickCount returns the clicks of an ad over the last window from the counter's
+// minute buckets, so it is only as precise as a minute
+func (s *ClickService) GetRecentClickCount(adID string, window time.Duration) (int64, error) {
+	return s.counters.Recent(clickCounterKey(adID), window)
+}
+
+func clickCounterKey(adID string) string {
+	return "ad:" + adID
+}
+
 func (s *ClickService) AdExists(adID string) (bool, error) {
 	return s.clickRepo.AdExists(adID)
 }
 
 // ParseTimeFrame parses a timeframe string in the format "int+unit" (e.g., "37m", "7h", "3d")
 func (s *ClickService) ParseTimeFrame(timeFrame string) (time.Duration, error) {
+	return parseTimeFrame(timeFrame)
+}
+
+func parseTimeFrame(timeFrame string) (time.Duration, error) {
 	if timeFrame == "" {
 		return 1 * time.Hour, nil // Default to 1 hour
 	}
@@ -232,3 +592,86 @@ func (s *ClickService) GetClickCountByTimeFrame(adID string, timeFrame string) (
 
 	return count, nil
 }
+
+// GetFlaggedClicks returns how many clicks on an ad were flagged as fraudulent within the
+// time frame, along with the latest of them
+func (s *ClickService) GetFlaggedClicks(adID string, timeFrame string, limit int) (int64, []model.Click, error) {
+	exists, err := s.AdExists(adID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !exists {
+		return 0, nil, gorm.ErrRecordNotFound
+	}
+
+	duration, err := s.ParseTimeFrame(timeFrame)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return s.clickRepo.GetFlaggedClicks(adID, duration, limit)
+}
+
+// GetClickTimeSeries buckets the clicks of an ad between from and to by interval (1m, 1h or 1d).
+// Buckets are aligned to minute, hour or midnight boundaries in loc and empty buckets are zero-filled.
+func (s *ClickService) GetClickTimeSeries(adID string, from, to time.Time, interval string, loc *time.Location) ([]model.ClickBucket, error) {
+	exists, err := s.AdExists(adID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	boundaries, err := bucketBoundaries(from.In(loc), to.In(loc), interval)
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.clickRepo.GetClickTimeSeries(adID, boundaries)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]model.ClickBucket, len(boundaries)-1)
+	for i := range series {
+		bucket := found[i] // zero value when the bucket had no clicks
+		bucket.Start = boundaries[i]
+		series[i] = bucket
+	}
+	return series, nil
+}
+
+// bucketBoundaries returns the start of every bucket plus the end of the last one
+func bucketBoundaries(from, to time.Time, interval string) ([]time.Time, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("%w: to must be after from", ErrInvalidTimeSeries)
+	}
+	var start time.Time
+	var next func(time.Time) time.Time
+	loc := from.Location()
+	switch interval {
+	case "1m":
+		start = time.Date(from.Year(), from.Month(), from.Day(), from.Hour(), from.Minute(), 0, 0, loc)
+		next = func(t time.Time) time.Time { return t.Add(time.Minute) }
+	case "1h":
+		// not Truncate, which aligns to UTC and breaks zones with a non-hour offset
+		start = time.Date(from.Year(), from.Month(), from.Day(), from.Hour(), 0, 0, 0, loc)
+		next = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	case "1d":
+		start = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+		// calendar days, so a DST change gives a 23 or 25 hour bucket
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	default:
+		return nil, fmt.Errorf("%w: interval must be 1m, 1h or 1d", ErrInvalidTimeSeries)
+	}
+
+	boundaries := []time.Time{start}
+	for t := start; t.Before(to); {
+		t = next(t)
+		boundaries = append(boundaries, t)
+		if len(boundaries)-1 > maxTimeSeriesBuckets {
+			return nil, fmt.Errorf("%w: range spans more than %d %s buckets", ErrInvalidTimeSeries, maxTimeSeriesBuckets, interval)
+		}
+	}
+	return boundaries, nil
+}
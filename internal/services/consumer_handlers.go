@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/pkg/logger"
+)
+
+// ClickConsumerHandler implements MessageHandler for the clicks topic
+type ClickConsumerHandler struct {
+	clickService *ClickService
+	log          *logger.Logger
+	workerID     int
+}
+
+func (h *ClickConsumerHandler) Handle(_ context.Context, message Message) {
+	h.consume(message)
+}
+
+// Release flushes the batch, so the clicks in it are acked before the partitions move on
+func (h *ClickConsumerHandler) Release() {
+	h.clickService.flush()
+}
+
+// consume hands a click message to the click service, which acks it once the click is
+// committed. Messages that can't be processed move on to the retry or dead-letter topic, the
+// worker keeps trying until they get there.
+func (h *ClickConsumerHandler) consume(message Message) {
+	delivery := Delivery{
+		Partition: fmt.Sprintf("%s/%d", message.Topic, message.Partition),
+		Attempt:   messageAttempt(message),
+		Value:     message.Value,
+		Ack:       message.Ack,
+	}
+
+	event, err := h.clickService.codec.Decode(message.Value)
+	if err != nil {
+		h.log.Logger.Errorf("Worker %d: Failed to decode click: %v", h.workerID, err)
+		// retrying won't fix a malformed message
+		reason := err
+		if err := handOff(h.clickService.stop, h.log, func() error {
+			return h.clickService.deadLetter(message.Key, message.Value, delivery.Attempt, reason)
+		}); err != nil {
+			h.log.Logger.Errorf("Worker %d: Gave up dead-lettering click: %v", h.workerID, err)
+			return
+		}
+		delivery.Ack()
+		return
+	}
+
+	if err := h.clickService.ProcessClick(event.Click, delivery); err != nil {
+		h.log.Logger.Errorf("Worker %d: Failed to process click: %v", h.workerID, err)
+		reason := err
+		if err := handOff(h.clickService.stop, h.log, func() error {
+			return h.clickService.retryClick(event.Click, delivery, reason)
+		}); err != nil {
+			h.log.Logger.Errorf("Worker %d: Gave up handing click back to the event bus: %v", h.workerID, err)
+			return
+		}
+		delivery.Ack()
+	}
+}
+
+// ClickRetryConsumerHandler consumes the retry topic, holding every message back until its
+// retry-at header has passed
+type ClickRetryConsumerHandler struct {
+	ClickConsumerHandler
+}
+
+func (h *ClickRetryConsumerHandler) Handle(ctx context.Context, message Message) {
+	if wait := time.Until(messageRetryAt(message)); wait > 0 {
+		select {
+		case <-ctx.Done():
+			// not acked, so it is consumed again by whoever gets the partition
+			return
+		case <-time.After(wait):
+		}
+	}
+	h.consume(message)
+}
+
+// ImpressionConsumerHandler implements MessageHandler for the impressions topic
+type ImpressionConsumerHandler struct {
+	impressionService *ImpressionService
+	log               *logger.Logger
+	workerID          int
+}
+
+func (h *ImpressionConsumerHandler) Handle(_ context.Context, message Message) {
+	var impression model.Impression
+	if err := json.Unmarshal(message.Value, &impression); err != nil {
+		// retrying won't fix a malformed message
+		h.log.Logger.Errorf("Worker %d: Dropping impression that failed to unmarshal: %v", h.workerID, err)
+		message.Ack()
+		return
+	}
+
+	// acked by the impression service once the impression is stored
+	if err := h.impressionService.ProcessImpression(impression, messageAttempt(message), message.Ack); err != nil {
+		h.log.Logger.Errorf("Worker %d: Failed to process impression: %v", h.workerID, err)
+	}
+}
+
+// Release flushes the batch, so the impressions in it are acked before the partitions move on
+func (h *ImpressionConsumerHandler) Release() {
+	h.impressionService.flush()
+}
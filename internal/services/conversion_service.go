@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/repo"
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/ArjunMalhotra/pkg/logger"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultConversionType = "conversion"
+	maxConversionTypeLen  = 64
+)
+
+// ErrInvalidConversion is returned when a conversion payload fails validation
+var ErrInvalidConversion = errors.New("invalid conversion")
+
+type ConversionService struct {
+	conversionRepo    *repo.ConversionRepo
+	clickRepo         *repo.ClickRepo
+	log               *logger.Logger
+	cb                *circuitbreaker.CircuitBreaker
+	attributionWindow time.Duration
+}
+
+func NewConversionService(conversionRepo *repo.ConversionRepo, clickRepo *repo.ClickRepo, log *logger.Logger, breakers *circuitbreaker.Registry, attributionWindow time.Duration) *ConversionService {
+	return &ConversionService{
+		conversionRepo:    conversionRepo,
+		clickRepo:         clickRepo,
+		log:               log,
+		cb:                breakers.Get(BreakerConversions),
+		attributionWindow: attributionWindow,
+	}
+}
+
+// RecordConversion stores a conversion for a click. The click may still be waiting in a batch,
+// so it is not looked up here; attribution is resolved when analytics are read.
+func (s *ConversionService) RecordConversion(conversion model.Conversion) (*model.Conversion, error) {
+	if _, err := uuid.Parse(conversion.ClickID); err != nil {
+		return nil, fmt.Errorf("%w: click_id must be the id returned when the click was recorded", ErrInvalidConversion)
+	}
+	conversion.Type = strings.TrimSpace(conversion.Type)
+	if conversion.Type == "" {
+		conversion.Type = defaultConversionType
+	}
+	if len(conversion.Type) > maxConversionTypeLen {
+		return nil, fmt.Errorf("%w: type must be at most %d characters", ErrInvalidConversion, maxConversionTypeLen)
+	}
+	if conversion.Value < 0 {
+		return nil, fmt.Errorf("%w: value cannot be negative", ErrInvalidConversion)
+	}
+	conversion.ID = uuid.New().String()
+	conversion.ConvertedAt = time.Now()
+
+	err := s.cb.Execute(context.Background(), func(context.Context) error {
+		return s.conversionRepo.Save(&conversion)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &conversion, nil
+}
+
+// GetConversionCountByTimeFrame counts the attributed conversions of an ad within a time frame
+func (s *ConversionService) GetConversionCountByTimeFrame(adID string, timeFrame string) (int64, error) {
+	exists, err := s.clickRepo.AdExists(adID)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	duration, err := parseTimeFrame(timeFrame)
+	if err != nil {
+		return 0, err
+	}
+
+	return circuitbreaker.ExecuteT(context.Background(), s.cb, func(context.Context) (int64, error) {
+		return s.conversionRepo.GetAttributedCountByTimeFrame(adID, duration, s.attributionWindow)
+	})
+}
+
+func (s *ConversionService) AttributionWindow() time.Duration {
+	return s.attributionWindow
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ArjunMalhotra/pkg/logger"
+)
+
+// ErrMessageNotFound is returned when there is no message at a partition and offset
+var ErrMessageNotFound = errors.New("message not found")
+
+// EventBus carries clicks and impressions from the HTTP handlers to their consumers.
+// KafkaService is backed by Kafka, MemoryBus keeps everything in process.
+type EventBus interface {
+	// Publish sends a message and waits until the bus has it
+	Publish(topic string, message Message) error
+	// Enqueue sends a message without waiting, failing with ErrQueueFull when the bus is backed up
+	Enqueue(topic string, message Message) error
+	// Subscribe runs workers handlers made by newHandler, which share the messages of topic
+	// as the consumer group group
+	Subscribe(group, topic string, workers int, newHandler func(workerID int) MessageHandler) (Subscription, error)
+	// Subscriptions reports the consumer groups this bus runs and what their workers are doing
+	Subscriptions() []SubscriptionInfo
+	// CreateTopic makes sure a topic exists, for topics that are published to but not subscribed
+	CreateTopic(topic string) error
+	// Read returns up to limit of the oldest messages still kept on topic, without consuming them
+	Read(topic string, limit int) ([]Message, error)
+	// ReadAt returns the message at partition and offset of topic, or ErrMessageNotFound
+	ReadAt(topic string, partition int32, offset int64) (Message, error)
+	// Ping checks that the bus can take messages, for Kafka that a broker answers
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Message is a published or consumed message. Partition, Offset and Timestamp are set by the bus.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	// messages with the same key keep their order
+	Key       string
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+
+	ack func()
+}
+
+// Ack marks a consumed message as done, so the group doesn't get it again
+func (m Message) Ack() {
+	if m.ack != nil {
+		m.ack()
+	}
+}
+
+// MessageHandler processes the messages of one subscription worker
+type MessageHandler interface {
+	// Handle processes a message and acks it, now or once its work is committed. ctx is done
+	// when the worker loses the message's partition.
+	Handle(ctx context.Context, message Message)
+	// Release is called when the worker stops or gives up its partitions, while acks still count
+	Release()
+}
+
+// Subscription is a running set of workers of a consumer group
+type Subscription interface {
+	// Stop ends the workers and waits until they released their handlers, or until ctx is done
+	Stop(ctx context.Context) error
+	Info() SubscriptionInfo
+}
+
+// SubscriptionStatus is what the workers of a subscription are doing
+type SubscriptionStatus string
+
+const (
+	// every worker that isn't stopped is consuming
+	StatusRunning SubscriptionStatus = "running"
+	// a worker is joining its group or waiting for partitions
+	StatusRebalancing SubscriptionStatus = "rebalancing"
+	// every worker has stopped
+	StatusStopped SubscriptionStatus = "stopped"
+)
+
+type SubscriptionInfo struct {
+	Group   string             `json:"group"`
+	Topic   string             `json:"topic"`
+	Workers int                `json:"workers"`
+	Status  SubscriptionStatus `json:"status"`
+}
+
+// subscription stops workers by cancelling their context and tracks the status of each worker
+type subscription struct {
+	group   string
+	topic   string
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	statusMutex sync.Mutex
+	statuses    []SubscriptionStatus // by worker ID
+}
+
+func newSubscription(group, topic string, workers int, cancel context.CancelFunc, status SubscriptionStatus) *subscription {
+	statuses := make([]SubscriptionStatus, workers)
+	for i := range statuses {
+		statuses[i] = status
+	}
+	return &subscription{group: group, topic: topic, cancel: cancel, statuses: statuses}
+}
+
+func (s *subscription) setStatus(workerID int, status SubscriptionStatus) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	s.statuses[workerID] = status
+}
+
+func (s *subscription) Info() SubscriptionInfo {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	status := StatusStopped
+	for _, worker := range s.statuses {
+		if worker == StatusRebalancing {
+			status = StatusRebalancing
+			break
+		}
+		if worker == StatusRunning {
+			status = StatusRunning
+		}
+	}
+	return SubscriptionInfo{Group: s.group, Topic: s.topic, Workers: len(s.statuses), Status: status}
+}
+
+func (s *subscription) Stop(ctx context.Context) error {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// how long a failed hand-off of a consumed message waits before trying again, doubled up to
+// maxHandOffBackoff
+const (
+	handOffBackoff    = 500 * time.Millisecond
+	maxHandOffBackoff = 30 * time.Second
+)
+
+// handOff runs publish, which passes a consumed message on to another topic, until it succeeds,
+// backing off in between. Acking a later message of the partition would commit past a message
+// that isn't handed off and lose it, so its partition waits instead. It only gives up once stop
+// is closed on shutdown, the message is consumed again after that.
+func handOff(stop <-chan struct{}, log *logger.Logger, publish func() error) error {
+	delay := handOffBackoff
+	for {
+		err := publish()
+		if err == nil {
+			return nil
+		}
+		log.Logger.Errorf("Failed to hand message back to the event bus, trying again in %s: %v", delay, err)
+		select {
+		case <-stop:
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxHandOffBackoff)
+	}
+}
+
+// headers of retried and dead-lettered messages
+const (
+	headerAttempt = "attempt"  // the attempt the message is on, 1 when there is no header
+	headerRetryAt = "retry-at" // unix milliseconds, the message isn't retried before
+	headerError   = "error"    // why the previous attempt failed
+)
+
+func messageAttempt(message Message) int {
+	attempt, err := strconv.Atoi(message.Headers[headerAttempt])
+	if err != nil || attempt < 1 {
+		return 1
+	}
+	return attempt
+}
+
+func messageRetryAt(message Message) time.Time {
+	millis, err := strconv.ParseInt(message.Headers[headerRetryAt], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/repo"
+	"github.com/ArjunMalhotra/pkg/logger"
+)
+
+// fraudThreshold is the summed rule score at which a click is flagged
+const fraudThreshold = 1.0
+
+// FraudRule scores a single click. A score of 1 or more on its own is enough to flag the click;
+// rules that are only suspicious can return a fraction and let other rules tip the balance.
+type FraudRule interface {
+	Name() string
+	Score(click model.Click) (score float64, reason string, err error)
+}
+
+// FraudVerdict is the combined outcome of all rules for a click
+type FraudVerdict struct {
+	Score   float64
+	Flagged bool
+	Reasons []string
+}
+
+type FraudDetector struct {
+	rules []FraudRule
+	log   *logger.Logger
+}
+
+func NewFraudDetector(log *logger.Logger, rules ...FraudRule) *FraudDetector {
+	return &FraudDetector{rules: rules, log: log}
+}
+
+// Evaluate runs every rule. A rule that errors is skipped so an unavailable
+// dependency never causes valid clicks to be dropped.
+func (d *FraudDetector) Evaluate(click model.Click) FraudVerdict {
+	var verdict FraudVerdict
+	for _, rule := range d.rules {
+		score, reason, err := rule.Score(click)
+		if err != nil {
+			d.log.Logger.Warnf("Fraud rule %s failed for click %s: %v", rule.Name(), click.ID, err)
+			continue
+		}
+		if score <= 0 {
+			continue
+		}
+		verdict.Score += score
+		verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("%s: %s", rule.Name(), reason))
+	}
+	verdict.Flagged = verdict.Score >= fraudThreshold
+	return verdict
+}
+
+// PendingClicks tallies the consumed clicks waiting in a batch by IP and ad, so the velocity
+// rules count them before they are stored
+type PendingClicks struct {
+	mutex  sync.Mutex
+	byIP   map[string]int
+	byIPAd map[ipAd]int
+}
+
+type ipAd struct {
+	ip   string
+	adID string
+}
+
+func NewPendingClicks() *PendingClicks {
+	return &PendingClicks{byIP: make(map[string]int), byIPAd: make(map[ipAd]int)}
+}
+
+// add counts clicks that joined a batch
+func (p *PendingClicks) add(clicks ...model.Click) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, click := range clicks {
+		p.byIP[click.IP]++
+		p.byIPAd[ipAd{click.IP, click.AdID}]++
+	}
+}
+
+// remove stops counting clicks that left their batch, stored or handed to the retry topic
+func (p *PendingClicks) remove(clicks ...model.Click) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, click := range clicks {
+		if p.byIP[click.IP]--; p.byIP[click.IP] <= 0 {
+			delete(p.byIP, click.IP)
+		}
+		key := ipAd{click.IP, click.AdID}
+		if p.byIPAd[key]--; p.byIPAd[key] <= 0 {
+			delete(p.byIPAd, key)
+		}
+	}
+}
+
+func (p *PendingClicks) CountByIP(ip string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.byIP[ip]
+}
+
+func (p *PendingClicks) CountByIPAndAd(ip, adID string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.byIPAd[ipAd{ip, adID}]
+}
+
+// IPVelocityRule flags IPs that click more than maxPerHour times across all ads
+type IPVelocityRule struct {
+	clickRepo  *repo.ClickRepo
+	pending    *PendingClicks
+	maxPerHour int
+}
+
+func NewIPVelocityRule(clickRepo *repo.ClickRepo, pending *PendingClicks, maxPerHour int) *IPVelocityRule {
+	return &IPVelocityRule{clickRepo: clickRepo, pending: pending, maxPerHour: maxPerHour}
+}
+
+func (r *IPVelocityRule) Name() string { return "ip_velocity" }
+
+func (r *IPVelocityRule) Score(click model.Click) (float64, string, error) {
+	count, err := r.clickRepo.GetClickCountByIP(click.IP)
+	if err != nil {
+		return 0, "", err
+	}
+	count += r.pending.CountByIP(click.IP)
+	if count < r.maxPerHour {
+		return 0, "", nil
+	}
+	return 1, fmt.Sprintf("%d clicks from %s in the last hour", count, click.IP), nil
+}
+
+// IPAdRepeatRule flags an IP clicking the same ad more than maxPerHour times
+type IPAdRepeatRule struct {
+	clickRepo  *repo.ClickRepo
+	pending    *PendingClicks
+	maxPerHour int
+}
+
+func NewIPAdRepeatRule(clickRepo *repo.ClickRepo, pending *PendingClicks, maxPerHour int) *IPAdRepeatRule {
+	return &IPAdRepeatRule{clickRepo: clickRepo, pending: pending, maxPerHour: maxPerHour}
+}
+
+func (r *IPAdRepeatRule) Name() string { return "ip_ad_repeat" }
+
+func (r *IPAdRepeatRule) Score(click model.Click) (float64, string, error) {
+	count, err := r.clickRepo.GetClickCountByIPAndAd(click.IP, click.AdID)
+	if err != nil {
+		return 0, "", err
+	}
+	count += r.pending.CountByIPAndAd(click.IP, click.AdID)
+	if count < r.maxPerHour {
+		return 0, "", nil
+	}
+	return 1, fmt.Sprintf("%d clicks on ad %s from %s in the last hour", count, click.AdID, click.IP), nil
+}
+
+// PlaybackTimeRule flags playback times no real viewer could produce
+type PlaybackTimeRule struct {
+	maxSeconds int
+}
+
+func NewPlaybackTimeRule(maxSeconds int) *PlaybackTimeRule {
+	return &PlaybackTimeRule{maxSeconds: maxSeconds}
+}
+
+func (r *PlaybackTimeRule) Name() string { return "playback_time" }
+
+func (r *PlaybackTimeRule) Score(click model.Click) (float64, string, error) {
+	if click.PlaybackTime < 0 || click.PlaybackTime > r.maxSeconds {
+		return 1, fmt.Sprintf("playback time %ds outside 0-%ds", click.PlaybackTime, r.maxSeconds), nil
+	}
+	return 0, "", nil
+}
+
+// DatacenterIPRule flags clicks coming from hosting provider ranges, where real users rarely are
+type DatacenterIPRule struct {
+	networks []*net.IPNet
+}
+
+func NewDatacenterIPRule(cidrs []string) (*DatacenterIPRule, error) {
+	rule := &DatacenterIPRule{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid datacenter range %q: %w", cidr, err)
+		}
+		rule.networks = append(rule.networks, network)
+	}
+	return rule, nil
+}
+
+func (r *DatacenterIPRule) Name() string { return "datacenter_ip" }
+
+func (r *DatacenterIPRule) Score(click model.Click) (float64, string, error) {
+	ip := net.ParseIP(click.IP)
+	if ip == nil {
+		return 0, "", nil
+	}
+	for _, network := range r.networks {
+		if network.Contains(ip) {
+			return 1, fmt.Sprintf("%s is in datacenter range %s", click.IP, network), nil
+		}
+	}
+	return 0, "", nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/ArjunMalhotra/pkg/db"
+	"github.com/ArjunMalhotra/pkg/logger"
+)
+
+// HealthStatus is how well a dependency, or the app as a whole, is doing
+type HealthStatus string
+
+const (
+	HealthUp HealthStatus = "up"
+	// working, but not at full strength, e.g. a consumer group rebalancing or a circuit open
+	HealthDegraded HealthStatus = "degraded"
+	HealthDown     HealthStatus = "down"
+)
+
+// DependencyHealth is the result of checking one dependency. The app isn't ready while a
+// critical dependency is down.
+type DependencyHealth struct {
+	Name     string       `json:"name"`
+	Status   HealthStatus `json:"status"`
+	Critical bool         `json:"critical"`
+	Error    string       `json:"error,omitempty"`
+	Details  interface{}  `json:"details,omitempty"`
+}
+
+type HealthReport struct {
+	Status       HealthStatus       `json:"status"`
+	Dependencies []DependencyHealth `json:"dependencies"`
+}
+
+// Ready reports whether every critical dependency is up or degraded
+func (r HealthReport) Ready() bool {
+	return r.Status != HealthDown
+}
+
+// BreakerHealth is the state of a circuit breaker in a health report
+type BreakerHealth struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// HealthService checks the dependencies the app needs to serve traffic
+type HealthService struct {
+	mysql    *db.MysqlDB
+	bus      EventBus
+	breakers *circuitbreaker.Registry
+	log      *logger.Logger
+	timeout  time.Duration
+}
+
+func NewHealthService(mysql *db.MysqlDB, bus EventBus, breakers *circuitbreaker.Registry, log *logger.Logger, timeout time.Duration) *HealthService {
+	return &HealthService{
+		mysql:    mysql,
+		bus:      bus,
+		breakers: breakers,
+		log:      log,
+		timeout:  timeout,
+	}
+}
+
+// Check checks every dependency at once, giving each up to the configured timeout
+func (s *HealthService) Check(ctx context.Context) HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	checks := []func(context.Context) DependencyHealth{
+		s.checkMySQL,
+		s.checkEventBus,
+		s.checkConsumers,
+		s.checkCircuitBreakers,
+	}
+	dependencies := make([]DependencyHealth, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func(context.Context) DependencyHealth) {
+			defer wg.Done()
+			dependencies[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: HealthUp, Dependencies: dependencies}
+	for _, dependency := range dependencies {
+		if dependency.Status == HealthUp {
+			continue
+		}
+		if dependency.Critical && dependency.Status == HealthDown {
+			s.log.Logger.Warnf("Health check of %s failed: %s", dependency.Name, dependency.Error)
+			report.Status = HealthDown
+		} else if report.Status == HealthUp {
+			report.Status = HealthDegraded
+		}
+	}
+	return report
+}
+
+func (s *HealthService) checkMySQL(ctx context.Context) DependencyHealth {
+	health := DependencyHealth{Name: "mysql", Status: HealthUp, Critical: true}
+	if err := s.mysql.Ping(ctx); err != nil {
+		health.Status = HealthDown
+		health.Error = err.Error()
+	}
+	return health
+}
+
+func (s *HealthService) checkEventBus(ctx context.Context) DependencyHealth {
+	health := DependencyHealth{Name: "event-bus", Status: HealthUp, Critical: true}
+	if err := s.bus.Ping(ctx); err != nil {
+		health.Status = HealthDown
+		health.Error = err.Error()
+	}
+	return health
+}
+
+// checkConsumers is down when a consumer group stopped, a group that is rebalancing only
+// delays consuming
+func (s *HealthService) checkConsumers(context.Context) DependencyHealth {
+	subscriptions := s.bus.Subscriptions()
+	health := DependencyHealth{Name: "consumers", Status: HealthUp, Critical: true, Details: subscriptions}
+	for _, info := range subscriptions {
+		switch info.Status {
+		case StatusStopped:
+			health.Status = HealthDown
+			health.Error = "consumer group " + info.Group + " has stopped"
+			return health
+		case StatusRebalancing:
+			health.Status = HealthDegraded
+		}
+	}
+	return health
+}
+
+// checkCircuitBreakers is degraded while a circuit is open. The services keep answering,
+// failing fast or handing work to the retry topic, so it isn't critical.
+func (s *HealthService) checkCircuitBreakers(context.Context) DependencyHealth {
+	breakers := s.breakers.All()
+	health := DependencyHealth{Name: "circuit-breakers", Status: HealthUp}
+	states := make([]BreakerHealth, len(breakers))
+	for i, cb := range breakers {
+		state := cb.State()
+		states[i] = BreakerHealth{Name: cb.Name(), State: state.String()}
+		if state == circuitbreaker.StateOpen {
+			health.Status = HealthDegraded
+		}
+	}
+	health.Details = states
+	return health
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ArjunMalhotra/config"
+	"github.com/ArjunMalhotra/internal/metrics"
+	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/internal/repo"
+	"github.com/ArjunMalhotra/pkg/circuitbreaker"
+	"github.com/ArjunMalhotra/pkg/dedup"
+	"github.com/ArjunMalhotra/pkg/logger"
+	"gorm.io/gorm"
+)
+
+type ImpressionService struct {
+	impressionRepo *repo.ImpressionRepo
+	clickRepo      *repo.ClickRepo
+	log            *logger.Logger
+	cb             *circuitbreaker.CircuitBreaker
+	bus            EventBus
+	topic          string
+	consumer       Subscription
+	processed      dedup.Store // IDs of impressions already consumed
+	maxAttempts    int         // stores of an impression before it is dropped
+
+	batchSize     int
+	flushInterval time.Duration
+	batchMutex    sync.Mutex
+	currentBatch  []pendingImpression
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// pendingImpression is a consumed impression waiting in the batch
+type pendingImpression struct {
+	impression model.Impression
+	// how often the impression was consumed, from 1
+	attempt int
+	// acks the message once the impression is stored or handed back to the event bus
+	ack func()
+}
+
+// NewImpressionService starts consuming impressions. Batches are stored when they hold
+// cfg.BatchSize impressions and at least every cfg.FlushInterval. A batch that fails is published
+// again, up to cfg.MaxAttempts times.
+func NewImpressionService(impressionRepo *repo.ImpressionRepo, clickRepo *repo.ClickRepo, log *logger.Logger, breakers *circuitbreaker.Registry, bus EventBus, stream config.StreamConfig, processed dedup.Store, cfg config.ImpressionConfig) *ImpressionService {
+	service := &ImpressionService{
+		impressionRepo: impressionRepo,
+		clickRepo:      clickRepo,
+		log:            log,
+		cb:             breakers.Get(BreakerImpressions),
+		bus:            bus,
+		topic:          stream.Topic,
+		processed:      processed,
+		maxAttempts:    cfg.MaxAttempts,
+		batchSize:      cfg.BatchSize,
+		flushInterval:  cfg.FlushInterval,
+		currentBatch:   make([]pendingImpression, 0, cfg.BatchSize),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	go service.runFlusher()
+
+	// Start consumer
+	consumer, err := bus.Subscribe(stream.Group, stream.Topic, stream.Workers, func(workerID int) MessageHandler {
+		return &ImpressionConsumerHandler{impressionService: service, log: log, workerID: workerID}
+	})
+	if err != nil {
+		log.Logger.Errorf("Failed to start impression consumer: %v", err)
+	} else {
+		service.consumer = consumer
+	}
+
+	return service
+}
+
+// Shutdown stops consuming impressions and stores the impressions still in the batch. It returns
+// early with ctx's error if that takes too long.
+func (s *ImpressionService) Shutdown(ctx context.Context) error {
+	// closed first, so a worker stuck republishing a batch gives up instead of holding up the
+	// consumer until ctx is done
+	s.stopOnce.Do(func() { close(s.stop) })
+	var err error
+	if s.consumer != nil {
+		// leaving the consumer group flushes the batch and commits its offsets
+		if stopErr := s.consumer.Stop(ctx); stopErr != nil {
+			err = fmt.Errorf("failed to stop impression consumer: %w", stopErr)
+		}
+	}
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.flush()
+	return err
+}
+
+// runFlusher stores the batch every flushInterval until Shutdown
+func (s *ImpressionService) runFlusher() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush stores whatever is in the batch
+func (s *ImpressionService) flush() {
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+	if err := s.processBatch(); err != nil {
+		s.log.Logger.Errorf("Failed to flush impression batch: %v", err)
+	}
+}
+
+// RecordImpression hands an impression to the event bus without waiting for it to be stored.
+// It fails with ErrQueueFull when the bus is backed up.
+func (s *ImpressionService) RecordImpression(impression model.Impression) error {
+	message, err := impressionMessage(impression, 1)
+	if err != nil {
+		return err
+	}
+	return s.bus.Enqueue(s.topic, message)
+}
+
+// publish sends an impression on its attempt and waits for the event bus to take it
+func (s *ImpressionService) publish(impression model.Impression, attempt int) error {
+	message, err := impressionMessage(impression, attempt)
+	if err != nil {
+		return err
+	}
+	return s.bus.Publish(s.topic, message)
+}
+
+// impressionMessage is the message of an impression on its attempt, keyed by its ad
+func impressionMessage(impression model.Impression, attempt int) (Message, error) {
+	msg, err := json.Marshal(impression)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal impression: %v", err)
+	}
+	message := Message{Key: impression.AdID, Value: msg}
+	if attempt > 1 {
+		message.Headers = map[string]string{headerAttempt: strconv.Itoa(attempt)}
+	}
+	return message, nil
+}
+
+// ProcessImpression adds a consumed impression to the batch. ack is called once the impression
+// is stored, dropped as a duplicate or handed back to the event bus, so its offset is only
+// committed then.
+func (s *ImpressionService) ProcessImpression(impression model.Impression, attempt int, ack func()) error {
+	// Check if we've already processed this impression
+	seen, err := s.processed.Seen(impression.ID)
+	if err != nil {
+		return fmt.Errorf("failed to check impression %s for duplicates: %w", impression.ID, err)
+	}
+	if seen {
+		metrics.DuplicatesDropped.WithLabelValues(metrics.StreamImpressions).Inc()
+		s.log.Logger.Debugf("Skipping duplicate impression ID: %s", impression.ID)
+		ack()
+		return nil
+	}
+
+	s.batchMutex.Lock()
+	defer s.batchMutex.Unlock()
+
+	s.currentBatch = append(s.currentBatch, pendingImpression{impression: impression, attempt: attempt, ack: ack})
+	if len(s.currentBatch) >= s.batchSize {
+		return s.processBatch()
+	}
+	return nil
+}
+
+// processBatch stores the batch and acks its impressions. Callers hold batchMutex.
+func (s *ImpressionService) processBatch() error {
+	if len(s.currentBatch) == 0 {
+		return nil
+	}
+	metrics.BatchSize.WithLabelValues(metrics.StreamImpressions).Observe(float64(len(s.currentBatch)))
+	start := time.Now()
+	defer func() {
+		metrics.BatchFlushDuration.WithLabelValues(metrics.StreamImpressions).Observe(time.Since(start).Seconds())
+		s.currentBatch = s.currentBatch[:0]
+	}()
+
+	impressions := make([]model.Impression, len(s.currentBatch))
+	for i, pending := range s.currentBatch {
+		impressions[i] = pending.impression
+	}
+	err := s.cb.Execute(context.Background(), func(context.Context) error {
+		return s.impressionRepo.SaveBatch(impressions)
+	})
+	if err != nil {
+		if !errors.Is(err, circuitbreaker.ErrOpen) {
+			s.log.Logger.Errorf("Failed to store impression batch in database: %v", err)
+		}
+		s.republishBatch()
+		return nil
+	}
+
+	ids := make([]string, len(impressions))
+	for i, impression := range impressions {
+		ids[i] = impression.ID
+	}
+	if err := s.processed.Mark(ids...); err != nil {
+		s.log.Logger.Errorf("Failed to mark impressions as processed: %v", err)
+	}
+	for _, pending := range s.currentBatch {
+		pending.ack()
+	}
+	return nil
+}
+
+// republishBatch publishes the batch again for another attempt and acks it, dropping the
+// impressions that have used up their attempts. It stops at the first impression that can't be
+// published, the impressions after it aren't acked either.
+func (s *ImpressionService) republishBatch() {
+	for _, pending := range s.currentBatch {
+		if pending.attempt >= s.maxAttempts {
+			s.log.Logger.Errorf("Dropping impression %s after %d attempts", pending.impression.ID, pending.attempt)
+			pending.ack()
+			continue
+		}
+		if err := handOff(s.stop, s.log, func() error { return s.publish(pending.impression, pending.attempt+1) }); err != nil {
+			s.log.Logger.Errorf("Gave up republishing impression %s: %v", pending.impression.ID, err)
+			return
+		}
+		pending.ack()
+	}
+}
+
+// GetImpressionCountByTimeFrame gets the impression count for an ad within a specific time frame
+func (s *ImpressionService) GetImpressionCountByTimeFrame(adID string, timeFrame string) (int64, error) {
+	exists, err := s.clickRepo.AdExists(adID)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	duration, err := parseTimeFrame(timeFrame)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.impressionRepo.GetImpressionCountByTimeFrame(adID, duration)
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ArjunMalhotra/config"
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// newSaramaConfig returns the connection settings shared by the producers, the consumer groups
+// and the admin client
+func newSaramaConfig(cfg config.KafkaConfig) (*sarama.Config, error) {
+	saramaConfig := sarama.NewConfig()
+	// the first version that supports zstd, consumers need it to read zstd batches as well
+	saramaConfig.Version = sarama.V2_1_0_0
+	saramaConfig.Net.DialTimeout = 10 * time.Second
+	saramaConfig.Net.ReadTimeout = 10 * time.Second
+	saramaConfig.Net.WriteTimeout = 10 * time.Second
+
+	if cfg.SASL.Mechanism != "" {
+		saramaConfig.Net.SASL.Enable = true
+		saramaConfig.Net.SASL.User = cfg.SASL.User
+		saramaConfig.Net.SASL.Password = cfg.SASL.Password
+		switch cfg.SASL.Mechanism {
+		case config.SASLPlain:
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case config.SASLScramSHA256:
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: scram.SHA256}
+			}
+		case config.SASLScramSHA512:
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: scram.SHA512}
+			}
+		default:
+			return nil, fmt.Errorf("unknown SASL mechanism %q, use %s, %s or %s", cfg.SASL.Mechanism, config.SASLPlain, config.SASLScramSHA256, config.SASLScramSHA512)
+		}
+	}
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		saramaConfig.Net.TLS.Enable = true
+		saramaConfig.Net.TLS.Config = tlsConfig
+	}
+	return saramaConfig, nil
+}
+
+// newProducerConfig adds the producer settings to the connection settings
+func newProducerConfig(base *sarama.Config, cfg config.KafkaConfig) (*sarama.Config, error) {
+	partitioner, err := newPartitioner(cfg.Partitioner)
+	if err != nil {
+		return nil, err
+	}
+	producerConfig := *base
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Retry.Max = maxRetries
+	producerConfig.Producer.Retry.Backoff = retryDelay
+	producerConfig.Producer.Partitioner = partitioner
+	if err := producerConfig.Producer.Compression.UnmarshalText([]byte(cfg.Producer.Compression)); err != nil {
+		return nil, err
+	}
+	return &producerConfig, nil
+}
+
+// newConsumerConfig adds the consumer group settings to the connection settings
+func newConsumerConfig(base *sarama.Config, cfg config.ConsumerConfig) (*sarama.Config, error) {
+	consumerConfig := *base
+	consumerConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	consumerConfig.Consumer.Group.Rebalance.Timeout = cfg.RebalanceTimeout
+	consumerConfig.Consumer.Group.Session.Timeout = cfg.SessionTimeout
+	consumerConfig.Consumer.Group.Heartbeat.Interval = cfg.HeartbeatInterval
+	switch cfg.InitialOffset {
+	case config.OffsetOldest:
+		consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case config.OffsetNewest:
+		consumerConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		return nil, fmt.Errorf("unknown initial offset %q, use %s or %s", cfg.InitialOffset, config.OffsetOldest, config.OffsetNewest)
+	}
+	if err := consumerConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid consumer config: %v", err)
+	}
+	return &consumerConfig, nil
+}
+
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in CA file %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// scramClient implements sarama.SCRAMClient
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
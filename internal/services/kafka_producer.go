@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ArjunMalhotra/config"
+	"github.com/ArjunMalhotra/internal/metrics"
+	"github.com/ArjunMalhotra/pkg/logger"
+	"github.com/Shopify/sarama"
+)
+
+// ErrQueueFull is returned when the producer already holds as many unacknowledged messages as
+// it may, callers should back off and try again
+var ErrQueueFull = errors.New("kafka producer queue is full")
+
+// ErrProducerClosed is returned for messages sent once the bus is closing
+var ErrProducerClosed = errors.New("kafka producer is closed")
+
+// asyncProducer publishes without waiting for the brokers. At most queueSize messages may be
+// unacknowledged at a time, beyond that sends fail fast instead of piling up.
+type asyncProducer struct {
+	producer  sarama.AsyncProducer
+	log       *logger.Logger
+	queueSize int64
+	queued    atomic.Int64
+	drained   sync.WaitGroup
+}
+
+// newAsyncProducer creates an async producer from the sync producer's config, adding the
+// batching settings of cfg
+func newAsyncProducer(brokers []string, base *sarama.Config, cfg config.ProducerConfig, log *logger.Logger) (*asyncProducer, error) {
+	saramaConfig := *base
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.Return.Errors = true
+	saramaConfig.Producer.Flush.Frequency = cfg.Linger
+	saramaConfig.Producer.Flush.Messages = cfg.BatchSize
+	// big enough that sends never block on sarama's internal channels
+	saramaConfig.ChannelBufferSize = cfg.QueueSize
+
+	producer, err := sarama.NewAsyncProducer(brokers, &saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+	p := &asyncProducer{
+		producer:  producer,
+		log:       log,
+		queueSize: int64(cfg.QueueSize),
+	}
+	p.drained.Add(2)
+	go func() {
+		defer p.drained.Done()
+		for message := range producer.Successes() {
+			p.onSuccess(message)
+		}
+	}()
+	go func() {
+		defer p.drained.Done()
+		for perr := range producer.Errors() {
+			p.onError(perr)
+		}
+	}()
+	return p, nil
+}
+
+// send queues a message, failing with ErrQueueFull rather than blocking
+func (p *asyncProducer) send(message *sarama.ProducerMessage) error {
+	if p.queued.Add(1) > p.queueSize {
+		p.queued.Add(-1)
+		metrics.KafkaProducerRejected.WithLabelValues(message.Topic).Inc()
+		return ErrQueueFull
+	}
+	metrics.KafkaProducerQueued.Set(float64(p.queued.Load()))
+	p.producer.Input() <- message
+	return nil
+}
+
+// queuedMessage is the metadata of a message sent by the async producer
+type queuedMessage struct {
+	key    string
+	queued time.Time
+}
+
+func (m queuedMessage) String() string {
+	return "message with key " + m.key
+}
+
+func (p *asyncProducer) onSuccess(message *sarama.ProducerMessage) {
+	metrics.KafkaProducerQueued.Set(float64(p.queued.Add(-1)))
+	recordPublish(message.Topic, message.Metadata.(queuedMessage).queued, nil)
+}
+
+func (p *asyncProducer) onError(perr *sarama.ProducerError) {
+	metrics.KafkaProducerQueued.Set(float64(p.queued.Add(-1)))
+	recordPublish(perr.Msg.Topic, perr.Msg.Metadata.(queuedMessage).queued, perr.Err)
+	// sarama already retried, the message is lost
+	p.log.Logger.Errorf("Failed to publish %v to %s: %v", perr.Msg.Metadata, perr.Msg.Topic, perr.Err)
+}
+
+// close flushes the queued messages and waits for their results
+func (p *asyncProducer) close() error {
+	err := p.producer.Close()
+	p.drained.Wait()
+	return err
+}
+
+// Enqueue publishes without waiting for the brokers. It returns ErrQueueFull when too many
+// messages are still unacknowledged, queued in async mode or being published in the background
+// in sync mode, and ErrProducerClosed once Close has started.
+func (s *KafkaService) Enqueue(topic string, message Message) error {
+	if !s.startPublish() {
+		return ErrProducerClosed
+	}
+	if s.async == nil {
+		select {
+		case s.inFlight <- struct{}{}:
+		default:
+			s.publishing.Done()
+			metrics.KafkaProducerRejected.WithLabelValues(topic).Inc()
+			return ErrQueueFull
+		}
+		metrics.KafkaProducerQueued.Set(float64(len(s.inFlight)))
+		go func() {
+			defer func() {
+				<-s.inFlight
+				metrics.KafkaProducerQueued.Set(float64(len(s.inFlight)))
+				s.publishing.Done()
+			}()
+			if err := s.publish(topic, message); err != nil {
+				s.log.Logger.Errorf("Failed to publish message with key %s to %s: %v", message.Key, topic, err)
+			}
+		}()
+		return nil
+	}
+	defer s.publishing.Done()
+	produced := producerMessage(topic, message)
+	produced.Metadata = queuedMessage{key: message.Key, queued: time.Now()}
+	return s.async.send(produced)
+}
+
+// recordPublish counts the outcome of a publish that started at sent
+func recordPublish(topic string, sent time.Time, err error) {
+	metrics.KafkaPublishDuration.WithLabelValues(topic).Observe(time.Since(sent).Seconds())
+	result := metrics.ResultSuccess
+	if err != nil {
+		result = metrics.ResultError
+	}
+	metrics.KafkaPublished.WithLabelValues(topic, result).Inc()
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// readTimeout bounds how long reading a message waits on the broker
+const readTimeout = 10 * time.Second
+
+// Read returns up to limit messages of topic, oldest first within each partition. Reading doesn't
+// consume them, they stay until the topic's retention drops them.
+func (s *KafkaService) Read(topic string, limit int) ([]Message, error) {
+	client, err := sarama.NewClient(s.brokers, s.consumerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %v", err)
+	}
+	messages := make([]Message, 0)
+	for _, partition := range partitions {
+		if len(messages) >= limit {
+			break
+		}
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get oldest offset: %v", err)
+		}
+		next, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get newest offset: %v", err)
+		}
+		if oldest >= next {
+			continue
+		}
+		read, err := readPartition(consumer, topic, partition, oldest, next, limit-len(messages))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, read...)
+	}
+	return messages, nil
+}
+
+func (s *KafkaService) ReadAt(topic string, partition int32, offset int64) (Message, error) {
+	client, err := sarama.NewClient(s.brokers, s.consumerConfig)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	next, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if errors.Is(err, sarama.ErrUnknownTopicOrPartition) || (err == nil && offset >= next) {
+		return Message{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to get newest offset: %v", err)
+	}
+	messages, err := readPartition(consumer, topic, partition, offset, next, 1)
+	if errors.Is(err, sarama.ErrOffsetOutOfRange) {
+		return Message{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return Message{}, err
+	}
+	if len(messages) == 0 || messages[0].Offset != offset {
+		return Message{}, ErrMessageNotFound
+	}
+	return messages[0], nil
+}
+
+// readPartition reads up to limit messages from offset up to, not including, next
+func readPartition(consumer sarama.Consumer, topic string, partition int32, offset, next int64, limit int) ([]Message, error) {
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	var messages []Message
+	for offset < next && len(messages) < limit {
+		select {
+		case message := <-pc.Messages():
+			messages = append(messages, consumedMessage(message, nil))
+			offset = message.Offset + 1
+		case <-time.After(readTimeout):
+			return nil, fmt.Errorf("timed out reading partition %d of %s at offset %d", partition, topic, offset)
+		}
+	}
+	return messages, nil
+}
@@ -2,49 +2,87 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/ArjunMalhotra/internal/model"
+	"github.com/ArjunMalhotra/config"
+	"github.com/ArjunMalhotra/internal/metrics"
 	"github.com/ArjunMalhotra/pkg/logger"
 	"github.com/Shopify/sarama"
 )
 
 const (
-	consumerGroupID = "ad-clicks-group"
-	topicName       = "ad-clicks"
-	maxRetries      = 5
-	retryDelay      = 2 * time.Second
+	maxRetries = 5
+	retryDelay = 2 * time.Second
 )
 
+// KafkaService is the EventBus backed by Kafka
 type KafkaService struct {
-	producer      sarama.SyncProducer
-	consumerGroup sarama.ConsumerGroup
-	log           *logger.Logger
-	config        *sarama.Config
-	brokers       []string
+	producer       sarama.SyncProducer
+	async          *asyncProducer // nil in sync mode
+	inFlight       chan struct{}  // bounds the background publishes of Enqueue in sync mode
+	closeMutex     sync.RWMutex
+	closing        bool           // set by Close, publishing fails from then on
+	publishing     sync.WaitGroup // publishes Close waits for before closing the producers
+	drainTimeout   time.Duration
+	consumersMutex sync.Mutex
+	consumers      []*kafkaConsumer
+	log            *logger.Logger
+	adminConfig    *sarama.Config
+	client         sarama.Client // kept open for the metadata checks of Ping
+	pingMutex      sync.Mutex
+	ping           *pingCall // the metadata refresh in flight, nil when there is none
+	consumerConfig *sarama.Config
+	brokers        []string
+	topics         config.TopicsConfig
 }
 
-func NewKafkaService(brokers []string, log *logger.Logger) (*KafkaService, error) {
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Retry.Max = maxRetries
-	config.Producer.Retry.Backoff = retryDelay
+// kafkaConsumer is a running consumer group and its worker loops. Every worker is a member of
+// the group of its own, a sarama.ConsumerGroup runs one session at a time.
+type kafkaConsumer struct {
+	*subscription
+	groups []sarama.ConsumerGroup
+}
 
-	// Add timeout settings
-	config.Net.DialTimeout = 10 * time.Second
-	config.Net.ReadTimeout = 10 * time.Second
-	config.Net.WriteTimeout = 10 * time.Second
+// close leaves the group with every worker
+func (c *kafkaConsumer) close() error {
+	var err error
+	for _, group := range c.groups {
+		if closeErr := group.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func NewKafkaService(cfg config.KafkaConfig, log *logger.Logger) (*KafkaService, error) {
+	brokers := cfg.Brokers
+	if cfg.Producer.Mode != config.ProducerModeSync && cfg.Producer.Mode != config.ProducerModeAsync {
+		return nil, fmt.Errorf("unknown producer mode %q", cfg.Producer.Mode)
+	}
+	baseConfig, err := newSaramaConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	producerConfig, err := newProducerConfig(baseConfig, cfg)
+	if err != nil {
+		return nil, err
+	}
+	consumerConfig, err := newConsumerConfig(baseConfig, cfg.Consumer)
+	if err != nil {
+		return nil, err
+	}
 
 	// Try to connect with retries
 	var producer sarama.SyncProducer
-	var err error
 
 	for i := 0; i < maxRetries; i++ {
 		log.Logger.Info("Attempting to connect to Kafka brokers: %v (attempt %d/%d)", brokers, i+1, maxRetries)
-		producer, err = sarama.NewSyncProducer(brokers, config)
+		producer, err = sarama.NewSyncProducer(brokers, producerConfig)
 		if err == nil {
 			log.Logger.Info("Successfully connected to Kafka")
 			break
@@ -57,134 +95,320 @@ func NewKafkaService(brokers []string, log *logger.Logger) (*KafkaService, error
 		return nil, fmt.Errorf("failed to create producer after %d attempts: %v", maxRetries, err)
 	}
 
-	return &KafkaService{
-		producer: producer,
-		config:   config,
-		brokers:  brokers,
-		log:      log,
-	}, nil
+	client, err := sarama.NewClient(brokers, baseConfig)
+	if err != nil {
+		producer.Close()
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+
+	service := &KafkaService{
+		producer:       producer,
+		adminConfig:    baseConfig,
+		client:         client,
+		consumerConfig: consumerConfig,
+		brokers:        brokers,
+		topics:         cfg.Topics,
+		drainTimeout:   cfg.Producer.DrainTimeout,
+		log:            log,
+	}
+	if cfg.Producer.Mode == config.ProducerModeSync {
+		service.inFlight = make(chan struct{}, cfg.Producer.QueueSize)
+	} else {
+		if service.async, err = newAsyncProducer(brokers, producerConfig, cfg.Producer, log); err != nil {
+			client.Close()
+			producer.Close()
+			return nil, fmt.Errorf("failed to create async producer: %v", err)
+		}
+	}
+	return service, nil
+}
+
+// newPartitioner returns the producer partitioner called name. The hash based ones keep every
+// message of an ad on one partition, random and roundrobin spread them regardless of the key.
+func newPartitioner(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case config.PartitionerHash:
+		return sarama.NewHashPartitioner, nil
+	case config.PartitionerReference:
+		return sarama.NewReferenceHashPartitioner, nil
+	case config.PartitionerCRC32:
+		return sarama.NewCustomHashPartitioner(crc32.NewIEEE), nil
+	case config.PartitionerRandom:
+		return sarama.NewRandomPartitioner, nil
+	case config.PartitionerRoundRobin:
+		return sarama.NewRoundRobinPartitioner, nil
+	}
+	return nil, fmt.Errorf("unknown partitioner %q", name)
 }
 
-func (s *KafkaService) PublishClick(click model.Click) error {
-	msg, err := json.Marshal(click)
-	if err != nil {
-		return fmt.Errorf("failed to marshal click: %v", err)
+// Publish sends a message and waits for the brokers to acknowledge it. Messages are
+// partitioned by key unless it is empty.
+func (s *KafkaService) Publish(topic string, message Message) error {
+	if !s.startPublish() {
+		return ErrProducerClosed
 	}
+	defer s.publishing.Done()
+	return s.publish(topic, message)
+}
 
-	_, _, err = s.producer.SendMessage(&sarama.ProducerMessage{
-		Topic: topicName,
-		Value: sarama.StringEncoder(msg),
-	})
+func (s *KafkaService) publish(topic string, message Message) error {
+	sent := time.Now()
+	_, _, err := s.producer.SendMessage(producerMessage(topic, message))
+	recordPublish(topic, sent, err)
 	if err != nil {
 		return fmt.Errorf("failed to send message: %v", err)
 	}
-
 	return nil
 }
 
-// ClickConsumerHandler implements sarama.ConsumerGroupHandler
-type ClickConsumerHandler struct {
-	clickService *ClickService
-	log          *logger.Logger
-	workerID     int
+func producerMessage(topic string, message Message) *sarama.ProducerMessage {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers))
+	for key, value := range message.Headers {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+	produced := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	}
+	if message.Key != "" {
+		produced.Key = sarama.StringEncoder(message.Key)
+	}
+	return produced
+}
+
+// consumedMessage converts a consumed message, ack marks it on session
+func consumedMessage(message *sarama.ConsumerMessage, session sarama.ConsumerGroupSession) Message {
+	headers := make(map[string]string, len(message.Headers))
+	for _, h := range message.Headers {
+		if h != nil {
+			headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	consumed := Message{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       string(message.Key),
+		Value:     message.Value,
+		Headers:   headers,
+		Timestamp: message.Timestamp,
+	}
+	if session != nil {
+		consumed.ack = func() { session.MarkMessage(message, "") }
+	}
+	return consumed
+}
+
+// consumerGroupHandler implements sarama.ConsumerGroupHandler for a MessageHandler
+type consumerGroupHandler struct {
+	handler  MessageHandler
+	consumer *kafkaConsumer
+	log      *logger.Logger
+	workerID int
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (h *ClickConsumerHandler) Setup(sarama.ConsumerGroupSession) error {
-	h.log.Logger.Infof("Worker %d: Consumer group setup", h.workerID)
+func (h *consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+	h.log.Logger.Infof("Worker %d: %s consumer group setup", h.workerID, h.consumer.topic)
+	h.consumer.setStatus(h.workerID, StatusRunning)
 	return nil
 }
 
-// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (h *ClickConsumerHandler) Cleanup(sarama.ConsumerGroupSession) error {
-	h.log.Logger.Infof("Worker %d: Consumer group cleanup", h.workerID)
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited.
+// The handler is released while the session can still commit the offsets it marks.
+func (h *consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	h.log.Logger.Infof("Worker %d: %s consumer group cleanup", h.workerID, h.consumer.topic)
+	h.consumer.setStatus(h.workerID, StatusRebalancing)
+	h.handler.Release()
 	return nil
 }
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages()
-func (h *ClickConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	lag := metrics.ConsumerLag.WithLabelValues(h.consumer.subscription.group, claim.Topic(), strconv.Itoa(int(claim.Partition())))
 	for message := range claim.Messages() {
-		var click model.Click
-		if err := json.Unmarshal(message.Value, &click); err != nil {
-			h.log.Logger.Errorf("Worker %d: Failed to unmarshal click: %v", h.workerID, err)
-			continue
-		}
-
-		if err := h.clickService.ProcessClick(click); err != nil {
-			h.log.Logger.Errorf("Worker %d: Failed to process click: %v", h.workerID, err)
-			continue
-		}
-
-		session.MarkMessage(message, "")
+		lag.Set(float64(claim.HighWaterMarkOffset() - message.Offset - 1))
+		h.handler.Handle(session.Context(), consumedMessage(message, session))
 	}
 	return nil
 }
 
-func (s *KafkaService) StartConsumer(clickService *ClickService, numWorkers int) error {
-	config := sarama.NewConfig()
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-
-	group, err := sarama.NewConsumerGroup(s.brokers, consumerGroupID, config)
-	if err != nil {
-		return fmt.Errorf("failed to create consumer group: %v", err)
+// Subscribe joins group on topic with workers members, creating the topic if it doesn't exist,
+// and runs their consume loops until the subscription is stopped or the service closed
+func (s *KafkaService) Subscribe(groupID, topic string, workers int, newHandler func(workerID int) MessageHandler) (Subscription, error) {
+	groups := make([]sarama.ConsumerGroup, workers)
+	for i := range groups {
+		group, err := sarama.NewConsumerGroup(s.brokers, groupID, s.consumerConfig)
+		if err != nil {
+			for _, joined := range groups[:i] {
+				joined.Close()
+			}
+			return nil, fmt.Errorf("failed to create consumer group: %v", err)
+		}
+		groups[i] = group
 	}
-	s.consumerGroup = group
+	ctx, cancel := context.WithCancel(context.Background())
+	// workers are rebalancing until their first session starts
+	consumer := &kafkaConsumer{
+		subscription: newSubscription(groupID, topic, workers, cancel, StatusRebalancing),
+		groups:       groups,
+	}
+	s.consumersMutex.Lock()
+	s.consumers = append(s.consumers, consumer)
+	s.consumersMutex.Unlock()
 
 	// Create topic if it doesn't exist
-	if err := s.CreateTopic(); err != nil {
+	if err := s.CreateTopic(topic); err != nil {
 		s.log.Logger.Warnf("Failed to create topic: %v", err)
 	}
 
 	// Start multiple workers
-	for i := 0; i < numWorkers; i++ {
-		go func(workerID int) {
-			handler := &ClickConsumerHandler{
-				clickService: clickService,
-				log:          s.log,
-				workerID:     workerID,
+	for i := 0; i < workers; i++ {
+		consumer.workers.Add(1)
+		go func(workerID int, group sarama.ConsumerGroup) {
+			defer consumer.workers.Done()
+			defer consumer.setStatus(workerID, StatusStopped)
+			handler := &consumerGroupHandler{
+				handler:  newHandler(workerID),
+				consumer: consumer,
+				log:      s.log,
+				workerID: workerID,
 			}
 			for {
-				err := group.Consume(context.Background(), []string{topicName}, handler)
+				err := group.Consume(ctx, []string{topic}, handler)
 				if err != nil {
-					s.log.Logger.Errorf("Worker %d: Error from consumer: %v", workerID, err)
+					s.log.Logger.Errorf("Worker %d: Error from %s consumer: %v", workerID, topic, err)
 				}
-				// Check if context was cancelled, indicating shutdown
-				if err == sarama.ErrClosedConsumerGroup {
+				// Check if context was cancelled or the group closed, indicating shutdown
+				if ctx.Err() != nil || err == sarama.ErrClosedConsumerGroup {
 					return
 				}
 				// Wait before retrying
-				time.Sleep(time.Second * 5)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second * 5):
+				}
 			}
-		}(i)
+		}(i, groups[i])
 	}
 
-	return nil
+	return consumer, nil
+}
+
+func (s *KafkaService) Subscriptions() []SubscriptionInfo {
+	s.consumersMutex.Lock()
+	defer s.consumersMutex.Unlock()
+
+	infos := make([]SubscriptionInfo, len(s.consumers))
+	for i, consumer := range s.consumers {
+		infos[i] = consumer.Info()
+	}
+	return infos
+}
+
+// startPublish registers a publish for Close to wait for. It returns false once Close has
+// started, the producers may already be closed.
+func (s *KafkaService) startPublish() bool {
+	s.closeMutex.RLock()
+	defer s.closeMutex.RUnlock()
+	if s.closing {
+		return false
+	}
+	s.publishing.Add(1)
+	return true
+}
+
+// drain stops new publishes and waits up to the drain timeout for the ones in flight
+func (s *KafkaService) drain() {
+	s.closeMutex.Lock()
+	s.closing = true
+	s.closeMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.publishing.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(s.drainTimeout):
+		s.log.Logger.Warnf("Closing the Kafka producer with messages still publishing after %s", s.drainTimeout)
+	}
 }
 
 func (s *KafkaService) Close() error {
+	s.drain()
+	if s.async != nil {
+		if err := s.async.close(); err != nil {
+			return fmt.Errorf("failed to close async producer: %v", err)
+		}
+	}
 	if err := s.producer.Close(); err != nil {
 		return fmt.Errorf("failed to close producer: %v", err)
 	}
-	if s.consumerGroup != nil {
-		if err := s.consumerGroup.Close(); err != nil {
+	s.consumersMutex.Lock()
+	defer s.consumersMutex.Unlock()
+	for _, consumer := range s.consumers {
+		consumer.cancel()
+		if err := consumer.close(); err != nil {
 			return fmt.Errorf("failed to close consumer group: %v", err)
 		}
 	}
+	if err := s.client.Close(); err != nil {
+		return fmt.Errorf("failed to close client: %v", err)
+	}
 	return nil
 }
 
-func (s *KafkaService) CreateTopic() error {
-	admin, err := sarama.NewClusterAdmin(s.brokers, s.config)
+// pingCall is a metadata refresh shared by the Pings that wait for it
+type pingCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Ping fetches the cluster metadata from the brokers, giving up when ctx is done. Concurrent
+// Pings share one refresh, so a broker that doesn't answer doesn't pile them up.
+func (s *KafkaService) Ping(ctx context.Context) error {
+	s.pingMutex.Lock()
+	call := s.ping
+	if call == nil {
+		call = &pingCall{done: make(chan struct{})}
+		s.ping = call
+		go func() {
+			if err := s.client.RefreshMetadata(); err != nil {
+				call.err = fmt.Errorf("failed to fetch metadata: %v", err)
+			}
+			s.pingMutex.Lock()
+			s.ping = nil
+			s.pingMutex.Unlock()
+			close(call.done)
+		}()
+	}
+	s.pingMutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CreateTopic creates a topic with the configured partitions and replication factor, a topic
+// that already exists is left as it is
+func (s *KafkaService) CreateTopic(topic string) error {
+	admin, err := sarama.NewClusterAdmin(s.brokers, s.adminConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create admin client: %v", err)
 	}
 	defer admin.Close()
-	err = admin.CreateTopic(topicName, &sarama.TopicDetail{
-		NumPartitions:     3,
-		ReplicationFactor: 1,
+	err = admin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     int32(s.topics.Partitions),
+		ReplicationFactor: int16(s.topics.Replication),
 	}, false)
-	if err != nil {
+	if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
 		return fmt.Errorf("failed to create topic %v", err)
 	}
 	return nil
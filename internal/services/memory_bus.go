@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/metrics"
+)
+
+// errBusClosed is returned when publishing to a closed MemoryBus
+var errBusClosed = errors.New("event bus is closed")
+
+// MemoryBus is an EventBus over channels, for running without Kafka. Every topic has a single
+// partition, every consumer group of a topic gets its own copy of each message and the workers
+// of a group share it. Nothing survives a restart and acks are no-ops, a message a worker was
+// holding when the process stopped is gone.
+type MemoryBus struct {
+	mutex     sync.Mutex
+	topics    map[string]*memoryTopic
+	buffer    int
+	retention int
+	subs      []*subscription
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// memoryTopic keeps the last retention messages of a topic for Read and ReadAt and a channel
+// of buffered messages per consumer group
+type memoryTopic struct {
+	next   int64 // offset of the next message
+	log    []Message
+	groups map[string]chan Message
+}
+
+// NewMemoryBus returns a bus holding up to buffer unconsumed messages per consumer group and
+// remembering the last retention messages of each topic
+func NewMemoryBus(buffer, retention int) *MemoryBus {
+	return &MemoryBus{
+		topics:    make(map[string]*memoryTopic),
+		buffer:    buffer,
+		retention: retention,
+		closed:    make(chan struct{}),
+	}
+}
+
+// topic returns the topic called name, callers hold the mutex
+func (b *MemoryBus) topic(name string) *memoryTopic {
+	topic, ok := b.topics[name]
+	if !ok {
+		topic = &memoryTopic{groups: make(map[string]chan Message)}
+		b.topics[name] = topic
+	}
+	return topic
+}
+
+// append stores a message on its topic and returns the channels of the groups to deliver it to
+func (b *MemoryBus) append(name string, message Message) (Message, []chan Message) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	topic := b.topic(name)
+	message.Topic = name
+	message.Offset = topic.next
+	message.Timestamp = time.Now()
+	topic.next++
+	topic.log = append(topic.log, message)
+	if len(topic.log) > b.retention {
+		topic.log = topic.log[len(topic.log)-b.retention:]
+	}
+	channels := make([]chan Message, 0, len(topic.groups))
+	for _, ch := range topic.groups {
+		channels = append(channels, ch)
+	}
+	return message, channels
+}
+
+// Publish waits until every consumer group of the topic has room for the message
+func (b *MemoryBus) Publish(topic string, message Message) error {
+	sent := time.Now()
+	message, channels := b.append(topic, message)
+	for _, ch := range channels {
+		select {
+		case ch <- message:
+		case <-b.closed:
+			return errBusClosed
+		}
+	}
+	recordPublish(topic, sent, nil)
+	return nil
+}
+
+// Enqueue fails with ErrQueueFull if a consumer group of the topic is buffer messages behind.
+// The message is still kept on the topic then, and delivered to the groups that had room.
+func (b *MemoryBus) Enqueue(topic string, message Message) error {
+	sent := time.Now()
+	message, channels := b.append(topic, message)
+	for _, ch := range channels {
+		select {
+		case ch <- message:
+		default:
+			return ErrQueueFull
+		}
+	}
+	recordPublish(topic, sent, nil)
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(group, topic string, workers int, newHandler func(workerID int) MessageHandler) (Subscription, error) {
+	b.mutex.Lock()
+	t := b.topic(topic)
+	ch, ok := t.groups[group]
+	if !ok {
+		ch = make(chan Message, b.buffer)
+		t.groups[group] = ch
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := newSubscription(group, topic, workers, cancel, StatusRunning)
+	b.subs = append(b.subs, sub)
+	b.mutex.Unlock()
+
+	lag := metrics.ConsumerLag.WithLabelValues(group, topic, "0")
+	for i := 0; i < workers; i++ {
+		sub.workers.Add(1)
+		go func(workerID int, handler MessageHandler) {
+			defer sub.workers.Done()
+			defer sub.setStatus(workerID, StatusStopped)
+			defer handler.Release()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case message := <-ch:
+					lag.Set(float64(len(ch)))
+					handler.Handle(ctx, message)
+				}
+			}
+		}(i, newHandler(i))
+	}
+	return sub, nil
+}
+
+func (b *MemoryBus) Subscriptions() []SubscriptionInfo {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	infos := make([]SubscriptionInfo, len(b.subs))
+	for i, sub := range b.subs {
+		infos[i] = sub.Info()
+	}
+	return infos
+}
+
+func (b *MemoryBus) CreateTopic(topic string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.topic(topic)
+	return nil
+}
+
+// Read returns the oldest of the last retention messages of a topic
+func (b *MemoryBus) Read(topic string, limit int) ([]Message, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	t := b.topic(topic)
+	if limit > len(t.log) {
+		limit = len(t.log)
+	}
+	messages := make([]Message, limit)
+	copy(messages, t.log)
+	return messages, nil
+}
+
+func (b *MemoryBus) ReadAt(topic string, partition int32, offset int64) (Message, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	t := b.topic(topic)
+	if partition != 0 || len(t.log) == 0 {
+		return Message{}, ErrMessageNotFound
+	}
+	i := offset - t.log[0].Offset
+	if i < 0 || i >= int64(len(t.log)) {
+		return Message{}, ErrMessageNotFound
+	}
+	return t.log[i], nil
+}
+
+func (b *MemoryBus) Ping(context.Context) error {
+	select {
+	case <-b.closed:
+		return errBusClosed
+	default:
+		return nil
+	}
+}
+
+// Close stops the subscriptions without waiting for them and fails blocked publishes
+func (b *MemoryBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		for _, sub := range b.subs {
+			sub.cancel()
+		}
+	})
+	return nil
+}
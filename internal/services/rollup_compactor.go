@@ -0,0 +1,124 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ArjunMalhotra/internal/repo"
+	"github.com/ArjunMalhotra/pkg/logger"
+)
+
+const (
+	// compactChunk bounds how much raw data a single compaction query reads
+	compactChunk = 24 * time.Hour
+)
+
+// RollupCompactor periodically folds raw clicks into the minute, hour and day rollup tables.
+// Buckets are recompacted for lateness after they close, which should cover the batching delay
+// of ClickService. Clicks stored later than that reopen their buckets through ReopenRollups.
+type RollupCompactor struct {
+	clickRepo *repo.ClickRepo
+	log       *logger.Logger
+	interval  time.Duration
+	lateness  time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func NewRollupCompactor(clickRepo *repo.ClickRepo, log *logger.Logger, interval, lateness time.Duration) *RollupCompactor {
+	return &RollupCompactor{
+		clickRepo: clickRepo,
+		log:       log,
+		interval:  interval,
+		lateness:  lateness,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (c *RollupCompactor) Start() {
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			c.CompactOnce(time.Now())
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop waits for a running compaction to finish
+func (c *RollupCompactor) Stop() {
+	c.once.Do(func() { close(c.stop) })
+	<-c.done
+}
+
+// CompactOnce brings every rollup up to date with now
+func (c *RollupCompactor) CompactOnce(now time.Time) {
+	for _, g := range repo.RollupGranularities {
+		if err := c.compact(g, now); err != nil {
+			c.log.Logger.Errorf("Failed to compact %s click rollup: %v", g.Name, err)
+		}
+	}
+}
+
+func (c *RollupCompactor) compact(g repo.RollupGranularity, now time.Time) error {
+	end := now.Add(-c.lateness).Truncate(g.Size)
+	watermark, err := c.clickRepo.GetRollupWatermark(g)
+	if err != nil {
+		return err
+	}
+
+	var from time.Time
+	if watermark.IsZero() {
+		first, ok, err := c.clickRepo.GetFirstClickTime()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// nothing recorded yet, the rollup is trivially complete
+			return c.clickRepo.SetRollupWatermark(g, end)
+		}
+		from = first.Truncate(g.Size)
+	} else {
+		if !watermark.Before(end) {
+			return nil
+		}
+		// recompute the buckets that may have received late clicks since the last pass
+		from = watermark.Add(-c.lateness).Truncate(g.Size)
+	}
+
+	for from.Before(end) {
+		to := from.Add(max(compactChunk, g.Size))
+		if to.After(end) {
+			to = end
+		}
+		if err := c.clickRepo.CompactRollup(g, from, to); err != nil {
+			return err
+		}
+		if watermark.IsZero() {
+			if err := c.clickRepo.SetRollupWatermark(g, to); err != nil {
+				return err
+			}
+		} else {
+			ok, err := c.clickRepo.AdvanceRollupWatermark(g, watermark, to)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// late clicks reopened the rollup while it was compacted, the next pass
+				// starts over from their buckets
+				return nil
+			}
+		}
+		watermark, from = to, to
+	}
+	return nil
+}
@@ -1,10 +1,16 @@
 package circuitbreaker
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 )
 
+// ErrOpen is the reason given for work that was skipped because a circuit is open
+var ErrOpen = errors.New("circuit breaker is open")
+
 type State int
 
 const (
@@ -13,69 +19,451 @@ const (
 	StateHalfOpen
 )
 
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// StateChangeFunc is called after the circuit called name moved from one state to another
+type StateChangeFunc func(name string, from, to State)
+
+// Option configures a CircuitBreaker
+type Option func(*CircuitBreaker)
+
+// WithFailureClassifier decides which errors of calls run by Execute count as failures. An
+// error it returns false for, e.g. a record that doesn't exist, counts as a success.
+// By default every error is a failure.
+func WithFailureClassifier(isFailure func(err error) bool) Option {
+	return func(cb *CircuitBreaker) {
+		cb.isFailure = isFailure
+	}
+}
+
+// WithStateChange adds a callback run on every state change, outside the breaker's lock
+func WithStateChange(fn StateChangeFunc) Option {
+	return func(cb *CircuitBreaker) {
+		cb.onStateChange = append(cb.onStateChange, fn)
+	}
+}
+
+// WithFailureRate also opens the circuit when more than rate (0 to 1) of the calls over the
+// last window failed, once there were at least minRequests of them. Pass a failure threshold
+// of 0 to NewCircuitBreaker to trip on the rate alone.
+func WithFailureRate(rate float64, window time.Duration, minRequests int) Option {
+	return func(cb *CircuitBreaker) {
+		cb.failureRate = rate
+		cb.minRequests = minRequests
+		cb.window = newRollingWindow(window)
+	}
+}
+
+// WithHalfOpenProbes lets up to probes calls through at a time while the circuit is half-open,
+// rejecting the others. The circuit closes once that many succeeded and opens again on the
+// first that fails. The default is a single probe.
+func WithHalfOpenProbes(probes int) Option {
+	return func(cb *CircuitBreaker) {
+		cb.halfOpenProbes = probes
+	}
+}
+
+// WithBackoff doubles how long the circuit stays open every time it opens again without
+// having closed in between, up to maxResetTimeout
+func WithBackoff(maxResetTimeout time.Duration) Option {
+	return func(cb *CircuitBreaker) {
+		cb.maxResetTimeout = maxResetTimeout
+	}
+}
+
+// WithSlowCallThreshold counts calls run by Execute that take threshold or longer as failures,
+// even when they succeed
+func WithSlowCallThreshold(threshold time.Duration) Option {
+	return func(cb *CircuitBreaker) {
+		cb.slowCallThreshold = threshold
+	}
+}
+
+// Stats is a snapshot of a circuit breaker
+type Stats struct {
+	Name  string `json:"name"`
+	State State  `json:"state"`
+	// failures counted towards opening the circuit, each success takes one off
+	Failures       int       `json:"failures"`
+	TotalSuccesses int64     `json:"total_successes"`
+	TotalFailures  int64     `json:"total_failures"`
+	SlowCalls      int64     `json:"slow_calls"` // also counted as failures
+	Rejected       int64     `json:"rejected"`   // calls skipped while the circuit was open
+	Trips          int64     `json:"trips"`
+	LastFailure    time.Time `json:"last_failure"`
+	StateChangedAt time.Time `json:"state_changed_at"`
+	// when an open circuit lets the next call through, nil unless it opened on failures
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	// the state was forced and stays until it is reset
+	Forced bool `json:"forced"`
+}
+
 type CircuitBreaker struct {
-	failureThreshold int
-	resetTimeout     time.Duration
-	state            State
-	failures         int
-	lastFailure      time.Time
-	mutex            sync.RWMutex
-	name             string
-}
-
-// NewCircuitBreaker creates a new circuit breaker
-func NewCircuitBreaker(failureThreshold int, resetTimeout time.Duration, name string) *CircuitBreaker {
-	return &CircuitBreaker{
+	name              string
+	failureThreshold  int // 0 leaves opening to the failure rate
+	resetTimeout      time.Duration
+	maxResetTimeout   time.Duration
+	failureRate       float64
+	minRequests       int
+	window            *rollingWindow // nil without a failure rate
+	halfOpenProbes    int
+	slowCallThreshold time.Duration
+	isFailure         func(err error) bool
+	onStateChange     []StateChangeFunc
+
+	mutex          sync.Mutex
+	state          State
+	generation     uint64 // changes with the state, outcomes of older calls are ignored
+	failures       int
+	probes         int // half-open calls in flight
+	probeSuccesses int
+	opens          int // times opened since the circuit last closed
+	openUntil      time.Time
+	forced         bool // ForceOpen or ForceClose pinned the state
+	trips          int64
+	successes      int64
+	totalFailures  int64
+	slowCalls      int64
+	rejected       int64
+	lastFailure    time.Time
+	stateChangedAt time.Time
+}
+
+// outcome is how a call went as far as the circuit is concerned
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeSlow
+	// the call was cut short by its caller and says nothing about the dependency
+	outcomeIgnored
+)
+
+// stateChange is a transition to report once the lock is released
+type stateChange struct {
+	from, to State
+}
+
+// NewCircuitBreaker creates a circuit breaker that opens after failureThreshold failures, each
+// success taking one off, and lets a call through again after resetTimeout
+func NewCircuitBreaker(failureThreshold int, resetTimeout time.Duration, name string, opts ...Option) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		name:             name,
 		failureThreshold: failureThreshold,
 		resetTimeout:     resetTimeout,
+		maxResetTimeout:  resetTimeout,
+		halfOpenProbes:   1,
+		isFailure:        func(error) bool { return true },
 		state:            StateClosed,
-		name:             name,
+		stateChangedAt:   time.Now(),
+	}
+	for _, opt := range opts {
+		opt(cb)
 	}
+	return cb
 }
 
-func (cb *CircuitBreaker) IsOpen() bool {
-	cb.mutex.RLock()
-	// If circuit is open, check if it's time to try again
-	if cb.state == StateOpen {
-		if time.Since(cb.lastFailure) > cb.resetTimeout {
-			// We need to upgrade to write lock
-			cb.mutex.RUnlock()
-			cb.mutex.Lock()
-			defer cb.mutex.Unlock()
-			// Double-check state hasn't changed
-			if cb.state == StateOpen && time.Since(cb.lastFailure) > cb.resetTimeout {
-				cb.state = StateHalfOpen
-				return false
-			}
-			return true
+// Execute runs fn unless the circuit is open, in which case it fails with ErrOpen, and records
+// its outcome. A call cut short because ctx is done isn't held against the circuit.
+func (cb *CircuitBreaker) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	generation, ok := cb.allow()
+	if !ok {
+		return fmt.Errorf("%w for %s", ErrOpen, cb.name)
+	}
+	start := time.Now()
+	err := fn(ctx)
+	result := outcomeSuccess
+	switch {
+	case err != nil && cb.isFailure(err):
+		result = outcomeFailure
+		if ctx.Err() != nil {
+			result = outcomeIgnored
 		}
-		cb.mutex.RUnlock()
-		return true
+	case cb.slowCallThreshold > 0 && time.Since(start) >= cb.slowCallThreshold:
+		result = outcomeSlow
 	}
-	cb.mutex.RUnlock()
-	return false
+	cb.record(generation, result)
+	return err
 }
 
-func (cb *CircuitBreaker) RecordFailure() {
+// ExecuteT is Execute for calls that return a value
+func ExecuteT[T any](ctx context.Context, cb *CircuitBreaker, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := cb.Execute(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// State returns the state the circuit is in. An open circuit stays open until the first call
+// after its reset timeout moves it to half-open.
+func (cb *CircuitBreaker) State() State {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.state
+}
+
+// Trips returns how many times failures opened the circuit, forcing it open doesn't count
+func (cb *CircuitBreaker) Trips() int64 {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
-	cb.failures++
-	cb.lastFailure = time.Now()
+	return cb.trips
+}
 
-	if cb.state == StateHalfOpen || cb.failures >= cb.failureThreshold {
-		cb.state = StateOpen // Open the circuit
+func (cb *CircuitBreaker) Stats() Stats {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	stats := Stats{
+		Name:           cb.name,
+		State:          cb.state,
+		Failures:       cb.failures,
+		TotalSuccesses: cb.successes,
+		TotalFailures:  cb.totalFailures,
+		SlowCalls:      cb.slowCalls,
+		Rejected:       cb.rejected,
+		Trips:          cb.trips,
+		LastFailure:    cb.lastFailure,
+		StateChangedAt: cb.stateChangedAt,
 	}
+	if cb.state == StateOpen && !cb.forced {
+		openUntil := cb.openUntil
+		stats.OpenUntil = &openUntil
+	}
+	stats.Forced = cb.forced
+	return stats
+}
+
+// IsOpen reports whether a call should be skipped. A call that isn't has to be followed by
+// RecordSuccess or RecordFailure, prefer Execute which does both.
+func (cb *CircuitBreaker) IsOpen() bool {
+	_, ok := cb.allow()
+	return !ok
+}
+
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.record(cb.currentGeneration(), outcomeFailure)
 }
 
 func (cb *CircuitBreaker) RecordSuccess() {
+	cb.record(cb.currentGeneration(), outcomeSuccess)
+}
+
+func (cb *CircuitBreaker) currentGeneration() uint64 {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
-	if cb.state == StateHalfOpen {
-		// Reset circuit after a successful operation in half-open state
-		cb.failures = 0
-		cb.state = StateClosed
-	} else if cb.state == StateClosed && cb.failures > 0 {
+	return cb.generation
+}
+
+// ForceOpen opens the circuit and keeps it open, rejecting every call, until ForceClose or Reset
+func (cb *CircuitBreaker) ForceOpen() {
+	cb.force(StateOpen)
+}
+
+// ForceClose closes the circuit and keeps it closed, letting every call through however many
+// fail, until ForceOpen or Reset
+func (cb *CircuitBreaker) ForceClose() {
+	cb.force(StateClosed)
+}
+
+func (cb *CircuitBreaker) force(state State) {
+	cb.mutex.Lock()
+	change := cb.setState(state)
+	cb.forced = true
+	cb.mutex.Unlock()
+	cb.notify(change)
+}
+
+// Reset closes the circuit, forgetting its failures and backoff, and lifts a forced state
+func (cb *CircuitBreaker) Reset() {
+	cb.mutex.Lock()
+	change := cb.setState(StateClosed)
+	cb.forced = false
+	cb.failures = 0
+	cb.opens = 0
+	if cb.window != nil {
+		cb.window.reset()
+	}
+	cb.mutex.Unlock()
+	cb.notify(change)
+}
+
+// allow decides whether a call may run and returns the generation it runs in. An open circuit
+// whose reset timeout passed turns half-open and lets the first probes through.
+func (cb *CircuitBreaker) allow() (uint64, bool) {
+	cb.mutex.Lock()
+	var change *stateChange
+	if cb.state == StateOpen && !cb.forced && !time.Now().Before(cb.openUntil) {
+		change = cb.setState(StateHalfOpen)
+	}
+	allowed := true
+	switch cb.state {
+	case StateOpen:
+		allowed = false
+	case StateHalfOpen:
+		if cb.probes >= cb.halfOpenProbes {
+			allowed = false
+		} else {
+			cb.probes++
+		}
+	}
+	if !allowed {
+		cb.rejected++
+	}
+	generation := cb.generation
+	cb.mutex.Unlock()
+	cb.notify(change)
+	return generation, allowed
+}
+
+// record applies the outcome of a call that started in generation
+func (cb *CircuitBreaker) record(generation uint64, result outcome) {
+	cb.mutex.Lock()
+	now := time.Now()
+	switch result {
+	case outcomeSuccess:
+		cb.successes++
+	case outcomeSlow:
+		cb.slowCalls++
+		fallthrough
+	case outcomeFailure:
+		cb.totalFailures++
+		cb.lastFailure = now
+	}
+	if generation != cb.generation || cb.forced {
+		// the circuit changed state while the call ran, or is held in its state
+		cb.mutex.Unlock()
+		return
+	}
+
+	var change *stateChange
+	switch {
+	case result == outcomeIgnored:
+		if cb.state == StateHalfOpen {
+			cb.probes--
+		}
+	case result == outcomeSuccess:
+		change = cb.onSuccess(now)
+	default:
+		change = cb.onFailure(now)
+	}
+	cb.mutex.Unlock()
+	cb.notify(change)
+}
+
+func (cb *CircuitBreaker) onSuccess(now time.Time) *stateChange {
+	switch cb.state {
+	case StateClosed:
+		if cb.window != nil {
+			cb.window.add(now, false)
+		}
 		// Decrease failure count on success
-		cb.failures--
+		if cb.failures > 0 {
+			cb.failures--
+		}
+	case StateHalfOpen:
+		cb.probes--
+		cb.probeSuccesses++
+		if cb.probeSuccesses >= cb.halfOpenProbes {
+			// Reset circuit once every probe succeeded
+			cb.failures = 0
+			cb.opens = 0
+			return cb.setState(StateClosed)
+		}
+	}
+	return nil
+}
+
+func (cb *CircuitBreaker) onFailure(now time.Time) *stateChange {
+	switch cb.state {
+	case StateClosed:
+		cb.failures++
+		if cb.window != nil {
+			cb.window.add(now, true)
+		}
+		if cb.shouldTrip(now) {
+			return cb.trip(now)
+		}
+	case StateHalfOpen:
+		return cb.trip(now)
+	}
+	return nil
+}
+
+// shouldTrip reports whether a closed circuit has seen enough failures to open
+func (cb *CircuitBreaker) shouldTrip(now time.Time) bool {
+	if cb.failureThreshold > 0 && cb.failures >= cb.failureThreshold {
+		return true
+	}
+	if cb.window == nil {
+		return false
+	}
+	requests, failures := cb.window.counts(now)
+	return requests >= cb.minRequests && float64(failures) > cb.failureRate*float64(requests)
+}
+
+// trip opens the circuit for the reset timeout, doubled for every time it opened since it
+// last closed
+func (cb *CircuitBreaker) trip(now time.Time) *stateChange {
+	timeout := cb.resetTimeout
+	for i := 0; i < cb.opens && timeout < cb.maxResetTimeout; i++ {
+		timeout *= 2
+	}
+	if timeout > cb.maxResetTimeout && cb.maxResetTimeout > cb.resetTimeout {
+		timeout = cb.maxResetTimeout
+	}
+	cb.opens++
+	cb.trips++
+	cb.openUntil = now.Add(timeout)
+	return cb.setState(StateOpen)
+}
+
+// setState moves the circuit to state, callers hold the lock and pass the returned change to
+// notify once they released it
+func (cb *CircuitBreaker) setState(state State) *stateChange {
+	if cb.state == state {
+		return nil
+	}
+	change := &stateChange{from: cb.state, to: state}
+	cb.state = state
+	cb.generation++
+	cb.stateChangedAt = time.Now()
+	cb.probes = 0
+	cb.probeSuccesses = 0
+	if cb.window != nil {
+		cb.window.reset()
+	}
+	return change
+}
+
+func (cb *CircuitBreaker) notify(change *stateChange) {
+	if change == nil {
+		return
+	}
+	for _, fn := range cb.onStateChange {
+		fn(cb.name, change.from, change.to)
 	}
 }
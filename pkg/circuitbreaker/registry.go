@@ -0,0 +1,95 @@
+package circuitbreaker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Settings are the thresholds of a circuit breaker. A zero FailureThreshold, FailureRate or
+// SlowCallThreshold turns that policy off, a zero MaxResetTimeout turns off the backoff.
+type Settings struct {
+	FailureThreshold  int
+	ResetTimeout      time.Duration
+	MaxResetTimeout   time.Duration
+	FailureRate       float64
+	Window            time.Duration
+	MinRequests       int
+	HalfOpenProbes    int
+	SlowCallThreshold time.Duration
+}
+
+func (s Settings) options() []Option {
+	var opts []Option
+	if s.FailureRate > 0 && s.Window > 0 {
+		opts = append(opts, WithFailureRate(s.FailureRate, s.Window, s.MinRequests))
+	}
+	if s.HalfOpenProbes > 0 {
+		opts = append(opts, WithHalfOpenProbes(s.HalfOpenProbes))
+	}
+	if s.MaxResetTimeout > 0 {
+		opts = append(opts, WithBackoff(s.MaxResetTimeout))
+	}
+	if s.SlowCallThreshold > 0 {
+		opts = append(opts, WithSlowCallThreshold(s.SlowCallThreshold))
+	}
+	return opts
+}
+
+// Registry hands out circuit breakers by name, so every user of a dependency shares one
+// breaker and the breakers can be listed and controlled in one place
+type Registry struct {
+	mutex     sync.Mutex
+	defaults  Settings
+	overrides map[string]Settings
+	opts      []Option
+	breakers  map[string]*CircuitBreaker
+}
+
+// NewRegistry returns a registry creating breakers with the settings of their name in
+// overrides, or defaults. opts are applied to every breaker.
+func NewRegistry(defaults Settings, overrides map[string]Settings, opts ...Option) *Registry {
+	return &Registry{
+		defaults:  defaults,
+		overrides: overrides,
+		opts:      opts,
+		breakers:  make(map[string]*CircuitBreaker),
+	}
+}
+
+// Get returns the breaker called name, creating it on first use
+func (r *Registry) Get(name string) *CircuitBreaker {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if cb, ok := r.breakers[name]; ok {
+		return cb
+	}
+	settings, ok := r.overrides[name]
+	if !ok {
+		settings = r.defaults
+	}
+	opts := append(settings.options(), r.opts...)
+	cb := NewCircuitBreaker(settings.FailureThreshold, settings.ResetTimeout, name, opts...)
+	r.breakers[name] = cb
+	return cb
+}
+
+// Lookup returns the breaker called name, if it was created
+func (r *Registry) Lookup(name string) (*CircuitBreaker, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	cb, ok := r.breakers[name]
+	return cb, ok
+}
+
+// All returns the breakers created so far, by name
+func (r *Registry) All() []*CircuitBreaker {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	breakers := make([]*CircuitBreaker, 0, len(r.breakers))
+	for _, cb := range r.breakers {
+		breakers = append(breakers, cb)
+	}
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].name < breakers[j].name })
+	return breakers
+}
@@ -0,0 +1,55 @@
+package circuitbreaker
+
+import "time"
+
+// windowBuckets is how many buckets a rolling window is split in, a bucket expires as a whole
+const windowBuckets = 10
+
+// rollingWindow counts calls and failures over the last size of time
+type rollingWindow struct {
+	bucket  time.Duration
+	buckets [windowBuckets]windowBucket
+}
+
+type windowBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+func newRollingWindow(size time.Duration) *rollingWindow {
+	bucket := size / windowBuckets
+	if bucket <= 0 {
+		bucket = size
+	}
+	return &rollingWindow{bucket: bucket}
+}
+
+func (w *rollingWindow) add(now time.Time, failed bool) {
+	start := now.Truncate(w.bucket)
+	b := &w.buckets[(start.UnixNano()/int64(w.bucket))%windowBuckets]
+	if !b.start.Equal(start) {
+		// the bucket last counted a previous turn of the window
+		*b = windowBucket{start: start}
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+// counts returns the calls and failures of the buckets still in the window at now
+func (w *rollingWindow) counts(now time.Time) (requests, failures int) {
+	oldest := now.Truncate(w.bucket).Add(-w.bucket * (windowBuckets - 1))
+	for _, b := range w.buckets {
+		if !b.start.Before(oldest) {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+func (w *rollingWindow) reset() {
+	w.buckets = [windowBuckets]windowBucket{}
+}
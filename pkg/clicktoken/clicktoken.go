@@ -0,0 +1,123 @@
+package clicktoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissing          = errors.New("click token is missing")
+	ErrMalformed        = errors.New("click token is malformed")
+	ErrUnknownKey       = errors.New("click token was signed with an unknown key")
+	ErrInvalidSignature = errors.New("click token signature is invalid")
+	ErrExpired          = errors.New("click token has expired")
+)
+
+// Claims is the payload of a click token
+type Claims struct {
+	KeyID        string `json:"kid"`
+	AdID         string `json:"ad"`
+	ImpressionID string `json:"imp"`
+	ExpiresAt    int64  `json:"exp"` // unix seconds
+}
+
+// Signer issues and verifies HMAC-SHA256 click tokens of the form payload.signature.
+// The first key signs new tokens; every key verifies, so a key can be rotated out by
+// moving it behind a new one and removing it once its tokens have expired.
+type Signer struct {
+	activeKeyID string
+	keys        map[string][]byte
+	ttl         time.Duration
+	now         func() time.Time
+}
+
+// Key is a named HMAC secret
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// NewSigner creates a signer, keys[0] is the signing key
+func NewSigner(keys []Key, ttl time.Duration) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("token ttl must be positive")
+	}
+	s := &Signer{
+		activeKeyID: keys[0].ID,
+		keys:        make(map[string][]byte, len(keys)),
+		ttl:         ttl,
+		now:         time.Now,
+	}
+	for _, key := range keys {
+		if key.ID == "" || len(key.Secret) == 0 {
+			return nil, errors.New("signing keys need an id and a secret")
+		}
+		if _, exists := s.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate signing key id %q", key.ID)
+		}
+		s.keys[key.ID] = key.Secret
+	}
+	return s, nil
+}
+
+// Issue returns a token allowing clicks on an ad tied to an impression. The token isn't
+// single use, it can be replayed until it expires.
+func (s *Signer) Issue(adID, impressionID string) (string, time.Time, error) {
+	expiresAt := s.now().Add(s.ttl)
+	payload, err := json.Marshal(Claims{
+		KeyID:        s.activeKeyID,
+		AdID:         adID,
+		ImpressionID: impressionID,
+		ExpiresAt:    expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(s.keys[s.activeKeyID], encoded), expiresAt, nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims
+func (s *Signer) Verify(token string) (*Claims, error) {
+	if token == "" {
+		return nil, ErrMissing
+	}
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrMalformed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	secret, ok := s.keys[claims.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(secret, encoded))) {
+		return nil, ErrInvalidSignature
+	}
+	if s.now().Unix() > claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	return &claims, nil
+}
+
+func (s *Signer) sign(secret []byte, encoded string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
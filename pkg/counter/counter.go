@@ -0,0 +1,40 @@
+package counter
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrWindowTooLong is returned when recent counts are asked for further back than buckets are kept
+var ErrWindowTooLong = errors.New("window is longer than the bucket retention")
+
+// Counter caches totals read from a source of truth, plus per-minute buckets of increments for
+// recent counts.
+//
+// Totals only grow: every total read from the source, before or after a write to it, is raised
+// to rather than added, so a stale read can't undo a newer one. A total that was never read or
+// has expired is simply read again from the source.
+type Counter interface {
+	// Incr adds delta to the minute bucket of at
+	Incr(key string, delta int64, at time.Time) error
+	// Get returns the total of key, ok is false when there is none
+	Get(key string) (count int64, ok bool, err error)
+	// Raise sets the total of key to count unless it already is higher
+	Raise(key string, count int64) error
+	// Recent sums the minute buckets of key covering the last window, including the current minute
+	Recent(key string, window time.Duration) (int64, error)
+}
+
+// minute returns the index of the minute bucket of t
+func minute(t time.Time) int64 {
+	return t.Unix() / 60
+}
+
+// windowMinutes returns how many minute buckets cover window, at least one
+func windowMinutes(window time.Duration) int64 {
+	minutes := int64((window + time.Minute - 1) / time.Minute)
+	if minutes < 1 {
+		return 1
+	}
+	return minutes
+}
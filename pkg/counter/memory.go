@@ -0,0 +1,110 @@
+package counter
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryCounter keeps counts in process memory, so every instance has its own and they are
+// lost on restart
+type MemoryCounter struct {
+	ttl       time.Duration
+	bucketTTL time.Duration
+
+	mutex   sync.Mutex
+	totals  map[string]memoryTotal
+	buckets map[string]map[int64]int64
+	now     func() time.Time
+}
+
+type memoryTotal struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// NewMemoryCounter creates a counter whose totals expire after ttl, to be read again from
+// the source, and whose minute buckets are kept for bucketTTL
+func NewMemoryCounter(ttl, bucketTTL time.Duration) *MemoryCounter {
+	return &MemoryCounter{
+		ttl:       ttl,
+		bucketTTL: bucketTTL,
+		totals:    make(map[string]memoryTotal),
+		buckets:   make(map[string]map[int64]int64),
+		now:       time.Now,
+	}
+}
+
+func (c *MemoryCounter) Incr(key string, delta int64, at time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	oldest := minute(now.Add(-c.bucketTTL))
+	bucket := minute(at)
+	if bucket <= oldest {
+		return nil
+	}
+	buckets, ok := c.buckets[key]
+	if !ok {
+		buckets = make(map[int64]int64)
+		c.buckets[key] = buckets
+	}
+	buckets[bucket] += delta
+	for b := range buckets {
+		if b <= oldest {
+			delete(buckets, b)
+		}
+	}
+	return nil
+}
+
+func (c *MemoryCounter) Get(key string) (int64, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	total, ok := c.total(key, c.now())
+	return total.count, ok, nil
+}
+
+func (c *MemoryCounter) Raise(key string, count int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	total, ok := c.total(key, now)
+	if !ok {
+		c.totals[key] = memoryTotal{count: count, expiresAt: now.Add(c.ttl)}
+	} else if total.count < count {
+		total.count = count
+		c.totals[key] = total
+	}
+	return nil
+}
+
+func (c *MemoryCounter) Recent(key string, window time.Duration) (int64, error) {
+	if window > c.bucketTTL {
+		return 0, ErrWindowTooLong
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	current := minute(c.now())
+	first := current - windowMinutes(window) + 1
+	var sum int64
+	for b, count := range c.buckets[key] {
+		if b >= first && b <= current {
+			sum += count
+		}
+	}
+	return sum, nil
+}
+
+// total returns the total of key, dropping it once it has expired. Callers hold the mutex.
+func (c *MemoryCounter) total(key string, now time.Time) (memoryTotal, bool) {
+	total, ok := c.totals[key]
+	if ok && !now.Before(total.expiresAt) {
+		delete(c.totals, key)
+		return memoryTotal{}, false
+	}
+	return total, ok
+}
@@ -0,0 +1,110 @@
+package counter
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const keyPrefix = "admetric:counter:"
+
+// incrScript bumps the minute bucket (KEYS[1]) and sets its expiry
+var incrScript = redis.NewScript(`
+redis.call('INCRBY', KEYS[1], ARGV[1])
+redis.call('EXPIREAT', KEYS[1], ARGV[2])
+return 1
+`)
+
+// raiseScript sets the total (KEYS[1]) to ARGV[1] unless it is higher, a new total expires
+// after ARGV[2] milliseconds and a raised one keeps its expiry
+var raiseScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if not current then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+elseif tonumber(current) < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1], 'KEEPTTL')
+end
+return 1
+`)
+
+// RedisCounter keeps counts in Redis, so they are shared by every instance and survive restarts.
+// Raising a total relies on SET KEEPTTL, which needs Redis 6.
+// Totals are plain keys with a TTL, minute buckets are keys suffixed with the unix minute.
+type RedisCounter struct {
+	client    *redis.Client
+	ttl       time.Duration
+	bucketTTL time.Duration
+}
+
+// NewRedisCounter creates a counter whose totals expire after ttl, to be read again from
+// the source, and whose minute buckets are kept for bucketTTL
+func NewRedisCounter(client *redis.Client, ttl, bucketTTL time.Duration) *RedisCounter {
+	return &RedisCounter{
+		client:    client,
+		ttl:       ttl,
+		bucketTTL: bucketTTL,
+	}
+}
+
+func (c *RedisCounter) Incr(key string, delta int64, at time.Time) error {
+	bucket := minute(at)
+	// a bucket too old to be in any window is left out
+	if bucket <= minute(time.Now().Add(-c.bucketTTL)) {
+		return nil
+	}
+	expireAt := (bucket+1)*60 + int64(c.bucketTTL.Seconds())
+	return incrScript.Run(c.client, []string{c.bucketKey(key, bucket)}, delta, expireAt).Err()
+}
+
+func (c *RedisCounter) Get(key string) (int64, bool, error) {
+	count, err := c.client.Get(c.totalKey(key)).Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return count, true, nil
+}
+
+func (c *RedisCounter) Raise(key string, count int64) error {
+	return raiseScript.Run(c.client, []string{c.totalKey(key)}, count, c.ttl.Milliseconds()).Err()
+}
+
+func (c *RedisCounter) Recent(key string, window time.Duration) (int64, error) {
+	if window > c.bucketTTL {
+		return 0, ErrWindowTooLong
+	}
+	current := minute(time.Now())
+	minutes := windowMinutes(window)
+	keys := make([]string, 0, minutes)
+	for b := current - minutes + 1; b <= current; b++ {
+		keys = append(keys, c.bucketKey(key, b))
+	}
+	values, err := c.client.MGet(keys...).Result()
+	if err != nil {
+		return 0, err
+	}
+	var sum int64
+	for _, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			continue // missing bucket
+		}
+		count, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		sum += count
+	}
+	return sum, nil
+}
+
+func (c *RedisCounter) totalKey(key string) string {
+	return keyPrefix + key
+}
+
+func (c *RedisCounter) bucketKey(key string, bucket int64) string {
+	return keyPrefix + key + ":m:" + strconv.FormatInt(bucket, 10)
+}
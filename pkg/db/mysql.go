@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -35,6 +36,7 @@ func NewMysqDB(cfg *config.Config) (*MysqlDB, error) {
 		PrepareStmt:                              true,
 		DisableForeignKeyConstraintWhenMigrating: true,
 		SkipDefaultTransaction:                   true, // Disable automatic transactions for read-only operations
+		TranslateError:                           true, // e.g. duplicate keys as gorm.ErrDuplicatedKey
 		NamingStrategy: schema.NamingStrategy{
 			TablePrefix:   "admetric_",
 			SingularTable: true,
@@ -62,8 +64,18 @@ func NewMysqDB(cfg *config.Config) (*MysqlDB, error) {
 	return dbc, nil
 }
 
+// Ping checks that a connection to the database can be used
+func (db *MysqlDB) Ping(ctx context.Context) error {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
 func (db *MysqlDB) Migrate() error {
-	if err := db.DB.AutoMigrate(&model.Ad{}, &model.Click{}); err != nil {
+	if err := db.DB.AutoMigrate(&model.Advertiser{}, &model.Campaign{}, &model.Ad{}, &model.Click{}, &model.Impression{}, &model.Conversion{},
+		&model.ClickRollupMinute{}, &model.ClickRollupHour{}, &model.ClickRollupDay{}, &model.ClickRollupWatermark{}); err != nil {
 		return err
 	}
 	return nil
@@ -87,10 +99,10 @@ func (db *MysqlDB) Seed() error {
 	//! seed
 	if err := tx.Create(&ads).Error; err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to seed data -> ", err)
+		return fmt.Errorf("failed to seed data -> %w", err)
 	}
 	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("failed to commit transaction -> ", err)
+		return fmt.Errorf("failed to commit transaction -> %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"github.com/ArjunMalhotra/config"
+	"github.com/go-redis/redis"
+)
+
+// NewRedisClient connects to Redis and pings it, so a wrong address fails at startup
+func NewRedisClient(cfg *config.Config) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
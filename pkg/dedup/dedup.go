@@ -0,0 +1,10 @@
+package dedup
+
+// Store remembers which message IDs were already processed. IDs are only marked once their
+// message is durably stored, so a message that failed or was lost in a crash is accepted again.
+type Store interface {
+	// Seen reports whether id was already processed
+	Seen(id string) (bool, error)
+	// Mark records ids as processed
+	Mark(ids ...string) error
+}
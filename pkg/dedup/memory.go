@@ -0,0 +1,87 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an LRU of recently seen IDs, bounded both in size and in how long an ID is
+// remembered. It only dedups within one process.
+type MemoryStore struct {
+	capacity int
+	ttl      time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently seen first
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a store that remembers at most capacity IDs, each for ttl after it
+// was last seen
+func NewMemoryStore(capacity int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) Seen(id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.evictExpired(now)
+	el, ok := s.entries[id]
+	if ok {
+		s.touch(el, now)
+	}
+	return ok, nil
+}
+
+func (s *MemoryStore) Mark(ids ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.evictExpired(now)
+	for _, id := range ids {
+		if el, ok := s.entries[id]; ok {
+			s.touch(el, now)
+			continue
+		}
+		s.entries[id] = s.order.PushFront(&memoryEntry{id: id, expiresAt: now.Add(s.ttl)})
+	}
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// touch makes el the most recently seen ID and renews it. Callers hold the mutex.
+func (s *MemoryStore) touch(el *list.Element, now time.Time) {
+	el.Value.(*memoryEntry).expiresAt = now.Add(s.ttl)
+	s.order.MoveToFront(el)
+}
+
+// evictExpired drops expired IDs from the back; every hit moves an ID to the front and renews
+// it, so the list is also ordered by expiry. Callers hold the mutex.
+func (s *MemoryStore) evictExpired(now time.Time) {
+	for el := s.order.Back(); el != nil && !now.Before(el.Value.(*memoryEntry).expiresAt); el = s.order.Back() {
+		s.remove(el)
+	}
+}
+
+func (s *MemoryStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*memoryEntry).id)
+}
@@ -0,0 +1,52 @@
+package dedup
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const keyPrefix = "admetric:dedup:"
+
+// RedisStore marks IDs with SETNX, so every consumer instance shares what was processed.
+// Keys expire ttl after an ID was first marked.
+type RedisStore struct {
+	client    *redis.Client
+	namespace string
+	ttl       time.Duration
+}
+
+// NewRedisStore creates a store whose IDs live under namespace, so click and impression IDs
+// don't collide
+func NewRedisStore(client *redis.Client, namespace string, ttl time.Duration) *RedisStore {
+	return &RedisStore{
+		client:    client,
+		namespace: namespace,
+		ttl:       ttl,
+	}
+}
+
+func (s *RedisStore) Seen(id string) (bool, error) {
+	n, err := s.client.Exists(s.key(id)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Mark sets a key per ID with SETNX, so marking an ID again doesn't extend how long it is kept
+func (s *RedisStore) Mark(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	pipe := s.client.Pipeline()
+	for _, id := range ids {
+		pipe.SetNX(s.key(id), 1, s.ttl)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+func (s *RedisStore) key(id string) string {
+	return keyPrefix + s.namespace + ":" + id
+}
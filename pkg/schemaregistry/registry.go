@@ -0,0 +1,105 @@
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNotFound is returned for a schema ID or subject the registry doesn't know
+var ErrNotFound = errors.New("schema not found")
+
+// magicByte starts every framed message, as in the Confluent wire format
+const magicByte = 0
+
+// Schema is one registered version of a subject's schema
+type Schema struct {
+	ID      int    `json:"id"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+	Schema  string `json:"schema"`
+}
+
+// Registry hands out IDs for schemas, so messages only need to carry the ID of the schema
+// they were written with
+type Registry interface {
+	// Register adds schema as the latest version of subject and returns it. Registering the
+	// latest schema again returns it unchanged.
+	Register(subject, schema string) (Schema, error)
+	// Lookup returns the schema with id
+	Lookup(id int) (Schema, error)
+	// Latest returns the newest version of subject
+	Latest(subject string) (Schema, error)
+}
+
+// LocalRegistry is an in-process stand-in for a schema registry server. IDs are handed out in
+// registration order, so processes registering the same schemas in the same order agree on them.
+type LocalRegistry struct {
+	mutex    sync.RWMutex
+	schemas  []Schema         // by ID - 1
+	subjects map[string][]int // IDs of every subject's versions, oldest first
+}
+
+func NewLocalRegistry() *LocalRegistry {
+	return &LocalRegistry{subjects: make(map[string][]int)}
+}
+
+func (r *LocalRegistry) Register(subject, schema string) (Schema, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	versions := r.subjects[subject]
+	if len(versions) > 0 {
+		if latest := r.schemas[versions[len(versions)-1]-1]; latest.Schema == schema {
+			return latest, nil
+		}
+	}
+	registered := Schema{
+		ID:      len(r.schemas) + 1,
+		Subject: subject,
+		Version: len(versions) + 1,
+		Schema:  schema,
+	}
+	r.schemas = append(r.schemas, registered)
+	r.subjects[subject] = append(versions, registered.ID)
+	return registered, nil
+}
+
+func (r *LocalRegistry) Lookup(id int) (Schema, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if id < 1 || id > len(r.schemas) {
+		return Schema{}, fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
+	return r.schemas[id-1], nil
+}
+
+func (r *LocalRegistry) Latest(subject string) (Schema, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	versions := r.subjects[subject]
+	if len(versions) == 0 {
+		return Schema{}, fmt.Errorf("%w: subject %s", ErrNotFound, subject)
+	}
+	return r.schemas[versions[len(versions)-1]-1], nil
+}
+
+// Frame prefixes payload with the magic byte and the big-endian schema ID
+func Frame(schemaID int, payload []byte) []byte {
+	framed := make([]byte, 5, 5+len(payload))
+	framed[0] = magicByte
+	binary.BigEndian.PutUint32(framed[1:], uint32(schemaID))
+	return append(framed, payload...)
+}
+
+// Unframe splits a framed message into its schema ID and payload, ok is false when data
+// isn't framed
+func Unframe(data []byte) (schemaID int, payload []byte, ok bool) {
+	if len(data) < 5 || data[0] != magicByte {
+		return 0, nil, false
+	}
+	return int(binary.BigEndian.Uint32(data[1:5])), data[5:], true
+}